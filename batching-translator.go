@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// NewBatchingTranslator wraps the translator to merge concurrent requests with the same folder, languages, format and glossary.
+// A request waits the window for others, the batch is sent earlier when it reaches the maxTexts.
+// The upstream call of a batch is limited by the timeout, 0 means no limit.
+func NewBatchingTranslator(translator Translator, window time.Duration, maxTexts int, timeout time.Duration) *BatchingTranslator {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &BatchingTranslator{translator: translator, window: window, maxTexts: maxTexts, timeout: timeout, ctx: ctx, cancel: cancel, batches: map[batchKey]*translateBatch{}}
+}
+
+type BatchingTranslator struct {
+	translator Translator
+	window     time.Duration
+	maxTexts   int
+	timeout    time.Duration
+	//the batches are shared by several clients, so they don't depend on a client's context
+	ctx     context.Context
+	cancel  context.CancelFunc
+	mu      sync.Mutex
+	batches map[batchKey]*translateBatch
+	lastID  uint64
+}
+
+type batchIDKey struct{}
+
+var _ Translator = (*BatchingTranslator)(nil)
+
+type batchKey struct {
+	FolderID           string
+	SourceLanguageCode string
+	TargetLanguageCode string
+	Format             string
+	Glossary           string
+}
+
+type translateBatch struct {
+	id      uint64
+	request TranslateRequest
+	timer   *time.Timer
+	sent    bool
+	done    chan struct{}
+	resp    *TranslateResponse
+	err     error
+}
+
+func (t *BatchingTranslator) Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	if t.maxTexts > 0 && len(request.Texts) >= t.maxTexts {
+		return t.translator.Translate(ctx, request)
+	}
+	key := batchKey{
+		FolderID:           request.FolderID,
+		SourceLanguageCode: request.SourceLanguageCode,
+		TargetLanguageCode: request.TargetLanguageCode,
+		Format:             request.Format,
+		Glossary:           glossaryHash(request),
+	}
+
+	t.mu.Lock()
+	b, ok := t.batches[key]
+	if !ok {
+		t.lastID++
+		b = &translateBatch{id: t.lastID, request: *request, done: make(chan struct{})}
+		b.request.Texts = nil
+		t.batches[key] = b
+		b.timer = time.AfterFunc(t.window, func() { t.send(key, b) })
+	}
+	offset := len(b.request.Texts)
+	b.request.Texts = append(b.request.Texts, request.Texts...)
+	full := t.maxTexts > 0 && len(b.request.Texts) >= t.maxTexts
+	if full {
+		//next requests start a new batch
+		delete(t.batches, key)
+	}
+	t.mu.Unlock()
+
+	serverLog.DebugCtx(ctx, "request joined batch", "batch_id", b.id, "texts", len(request.Texts))
+	if full {
+		go t.send(key, b)
+	}
+	select {
+	case <-b.done:
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	if b.err != nil {
+		return nil, b.err
+	}
+	return &TranslateResponse{Translations: b.resp.Translations[offset : offset+len(request.Texts)]}, nil
+}
+
+func (t *BatchingTranslator) send(key batchKey, b *translateBatch) {
+	t.mu.Lock()
+	if b.sent {
+		t.mu.Unlock()
+		return
+	}
+	b.sent = true
+	b.timer.Stop()
+	if t.batches[key] == b {
+		delete(t.batches, key)
+	}
+	t.mu.Unlock()
+
+	defer close(b.done)
+	ctx := context.WithValue(t.ctx, batchIDKey{}, b.id)
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	request := b.request
+	serverLog.DebugCtx(ctx, "send batch", "texts", len(request.Texts))
+	resp, err := t.translator.Translate(ctx, &request)
+	if err != nil {
+		b.err = err
+	} else if len(resp.Translations) != len(b.request.Texts) {
+		b.err = fmt.Errorf("unexpected translations amount %d, expected %d", len(resp.Translations), len(b.request.Texts))
+	} else {
+		b.resp = resp
+	}
+}
+
+// Close cancels the upstream calls of the batches in progress
+func (t *BatchingTranslator) Close() {
+	t.cancel()
+}
+
+func (t *BatchingTranslator) Detect(ctx context.Context, request *DetectRequest) (*DetectResponse, error) {
+	return t.translator.Detect(ctx, request)
+}
+
+func (t *BatchingTranslator) ListLanguages(ctx context.Context, request *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return t.translator.ListLanguages(ctx, request)
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+// translatorFunc is a Translator translating by the function
+type translatorFunc func(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error)
+
+func (f translatorFunc) Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	return f(ctx, request)
+}
+
+func (f translatorFunc) Detect(context.Context, *DetectRequest) (*DetectResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (f translatorFunc) ListLanguages(context.Context, *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+// translateConcurrently sends the requests at once and returns the translations by the request index
+func translateConcurrently(t *testing.T, translator Translator, requests []*TranslateRequest) [][]string {
+	t.Helper()
+	results := make([][]string, len(requests))
+	var wg sync.WaitGroup
+	for i, request := range requests {
+		wg.Add(1)
+		go func(i int, request *TranslateRequest) {
+			defer wg.Done()
+			resp, err := translator.Translate(context.Background(), request)
+			if err != nil {
+				t.Errorf("request %d: %v", i, err)
+				return
+			}
+			for _, translation := range resp.Translations {
+				results[i] = append(results[i], translation.Text)
+			}
+		}(i, request)
+	}
+	wg.Wait()
+	return results
+}
+
+func TestBatchingTranslatorMerge(t *testing.T) {
+	upstream := &countingTranslator{}
+	batching := NewBatchingTranslator(upstream, 100*time.Millisecond, 100, time.Second)
+	requests := make([]*TranslateRequest, 10)
+	for i := range requests {
+		texts := []string{fmt.Sprintf("a%d", i)}
+		if i%2 == 0 {
+			texts = append(texts, fmt.Sprintf("b%d", i), fmt.Sprintf("c%d", i))
+		}
+		requests[i] = &TranslateRequest{FolderID: "f", TargetLanguageCode: "en", Texts: texts}
+	}
+	results := translateConcurrently(t, batching, requests)
+	if len(upstream.requests) != 1 {
+		t.Errorf("upstream requests %d, expected 1: %q", len(upstream.requests), upstream.requests)
+	}
+	if sent := upstream.texts(); len(sent) != 20 {
+		t.Errorf("upstream texts %d, expected 20", len(sent))
+	}
+	for i, request := range requests {
+		if !reflect.DeepEqual(results[i], upper(request.Texts...)) {
+			t.Errorf("request %d: translations %q, expected %q", i, results[i], upper(request.Texts...))
+		}
+	}
+}
+
+func TestBatchingTranslatorSeparatesKeys(t *testing.T) {
+	upstream := &countingTranslator{}
+	batching := NewBatchingTranslator(upstream, 50*time.Millisecond, 100, time.Second)
+	requests := []*TranslateRequest{
+		{TargetLanguageCode: "en", Texts: []string{"a"}},
+		{TargetLanguageCode: "de", Texts: []string{"b"}},
+		{TargetLanguageCode: "en", Format: "HTML", Texts: []string{"c"}},
+		{TargetLanguageCode: "en", Texts: []string{"d"}, GlossaryConfig: &GlossaryConfig{GlossaryData: GlossaryData{GlossaryPairs: []GlossaryPair{{SourceText: "d", TranslatedText: "D"}}}}},
+		{TargetLanguageCode: "en", Texts: []string{"e"}},
+	}
+	results := translateConcurrently(t, batching, requests)
+	if len(upstream.requests) != 4 {
+		t.Errorf("upstream requests %d, expected 4: %q", len(upstream.requests), upstream.requests)
+	}
+	for i, request := range requests {
+		if !reflect.DeepEqual(results[i], upper(request.Texts...)) {
+			t.Errorf("request %d: translations %q, expected %q", i, results[i], upper(request.Texts...))
+		}
+	}
+}
+
+func TestBatchingTranslatorSendsFullBatchEarly(t *testing.T) {
+	upstream := &countingTranslator{}
+	//the window never ends in the test
+	batching := NewBatchingTranslator(upstream, time.Hour, 4, time.Second)
+	requests := []*TranslateRequest{
+		{TargetLanguageCode: "en", Texts: []string{"a", "b"}},
+		{TargetLanguageCode: "en", Texts: []string{"c", "d"}},
+	}
+	done := make(chan [][]string)
+	go func() { done <- translateConcurrently(t, batching, requests) }()
+	select {
+	case results := <-done:
+		for i, request := range requests {
+			if !reflect.DeepEqual(results[i], upper(request.Texts...)) {
+				t.Errorf("request %d: translations %q, expected %q", i, results[i], upper(request.Texts...))
+			}
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the full batch is not sent")
+	}
+	if len(upstream.requests) != 1 {
+		t.Errorf("upstream requests %d, expected 1", len(upstream.requests))
+	}
+
+	//a request reaching the max texts is not batched
+	upstream.reset()
+	if texts := translateTexts(t, batching, "a", "b", "c", "d", "e"); !reflect.DeepEqual(texts, upper("a", "b", "c", "d", "e")) {
+		t.Errorf("translations %q", texts)
+	}
+	if len(upstream.requests) != 1 {
+		t.Errorf("upstream requests %d, expected 1", len(upstream.requests))
+	}
+}
+
+func TestBatchingTranslatorCancelledWaiter(t *testing.T) {
+	release := make(chan struct{})
+	upstream := &countingTranslator{}
+	blocking := translatorFunc(func(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+		<-release
+		return upstream.Translate(ctx, request)
+	})
+	batching := NewBatchingTranslator(blocking, 20*time.Millisecond, 100, time.Second)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancelled := make(chan error)
+	go func() {
+		_, err := batching.Translate(ctx, &TranslateRequest{TargetLanguageCode: "en", Texts: []string{"a"}})
+		cancelled <- err
+	}()
+	waiting := make(chan []string)
+	go func() {
+		resp, err := batching.Translate(context.Background(), &TranslateRequest{TargetLanguageCode: "en", Texts: []string{"b", "c"}})
+		if err != nil {
+			t.Error(err)
+			close(waiting)
+			return
+		}
+		waiting <- []string{resp.Translations[0].Text, resp.Translations[1].Text}
+	}()
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	if err := <-cancelled; !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, expected cancellation", err)
+	}
+	//the batch goes on for the other request
+	close(release)
+	if texts := <-waiting; !reflect.DeepEqual(texts, []string{"B", "C"}) {
+		t.Errorf("translations %q, expected B and C", texts)
+	}
+	sent := upstream.texts()
+	sort.Strings(sent)
+	if !reflect.DeepEqual(sent, []string{"a", "b", "c"}) {
+		t.Errorf("upstream texts %q", sent)
+	}
+}
+
+func TestBatchingTranslatorTimeoutAndClose(t *testing.T) {
+	var mu sync.Mutex
+	var batchIDs []any
+	hanging := translatorFunc(func(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+		mu.Lock()
+		batchIDs = append(batchIDs, ctx.Value(batchIDKey{}))
+		mu.Unlock()
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+
+	batching := NewBatchingTranslator(hanging, time.Millisecond, 100, 50*time.Millisecond)
+	for i := 0; i < 2; i++ {
+		if _, err := batching.Translate(context.Background(), &TranslateRequest{Texts: []string{"a"}}); !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("error %v, expected the batch timeout", err)
+		}
+	}
+	if expected := []any{uint64(1), uint64(2)}; !reflect.DeepEqual(batchIDs, expected) {
+		t.Errorf("batch IDs %v, expected %v", batchIDs, expected)
+	}
+
+	batching = NewBatchingTranslator(hanging, time.Millisecond, 100, 0)
+	time.AfterFunc(50*time.Millisecond, batching.Close)
+	if _, err := batching.Translate(context.Background(), &TranslateRequest{Texts: []string{"a"}}); !errors.Is(err, context.Canceled) {
+		t.Errorf("error %v, expected cancellation by close", err)
+	}
+}
+
+func TestBatchingTranslatorMissingTranslations(t *testing.T) {
+	short := translatorFunc(func(context.Context, *TranslateRequest) (*TranslateResponse, error) {
+		return &TranslateResponse{Translations: []Translation{{Text: "x"}}}, nil
+	})
+	batching := NewBatchingTranslator(short, 50*time.Millisecond, 100, time.Second)
+	var wg sync.WaitGroup
+	for _, text := range []string{"a", "b"} {
+		wg.Add(1)
+		go func(text string) {
+			defer wg.Done()
+			if _, err := batching.Translate(context.Background(), &TranslateRequest{Texts: []string{text}}); err == nil {
+				t.Error("missing translations are not reported")
+			}
+		}(text)
+	}
+	wg.Wait()
+}
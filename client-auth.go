@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+const (
+	anyLanguage = "*"
+)
+
+// APIKey grants a client access to the proxy
+type APIKey struct {
+	Name string
+	Key  string
+	//absent means enabled
+	Enabled *bool `yaml:",omitempty"`
+	//allowed source-target language pairs like en-ru, * matches any language, empty means all pairs
+	LanguagePairs []string
+}
+
+// IsEnabled checks the key is not disabled explicitly
+func (k *APIKey) IsEnabled() bool {
+	return k.Enabled == nil || *k.Enabled
+}
+
+// AllowsLanguagePair checks the pair, an empty source means the source is detected
+func (k *APIKey) AllowsLanguagePair(source, target string) bool {
+	if len(k.LanguagePairs) == 0 {
+		return true
+	}
+	for _, pair := range k.LanguagePairs {
+		allowedSource, allowedTarget, _ := strings.Cut(strings.ToLower(pair), "-")
+		if matchesLanguage(allowedSource, source) && matchesLanguage(allowedTarget, target) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchesLanguage(allowed, language string) bool {
+	return allowed == anyLanguage || (len(language) > 0 && allowed == strings.ToLower(language))
+}
+
+func validateAPIKeys(keys []APIKey) error {
+	names := map[string]bool{}
+	for _, key := range keys {
+		if len(key.Name) == 0 || len(key.Key) == 0 {
+			return errors.New("API key must contain name and key")
+		} else if names[key.Name] {
+			return fmt.Errorf("duplicated API key name %s", key.Name)
+		}
+		names[key.Name] = true
+		for _, pair := range key.LanguagePairs {
+			if source, target, ok := strings.Cut(pair, "-"); !ok || len(source) == 0 || len(target) == 0 {
+				return fmt.Errorf("invalid language pair %s of the API key %s", pair, key.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// findAPIKey returns the enabled key or nil
+func findAPIKey(keys []APIKey, key string) *APIKey {
+	var found *APIKey
+	for i := range keys {
+		//compares all keys in constant time to not expose a key by the response time
+		if subtle.ConstantTimeCompare([]byte(keys[i].Key), []byte(key)) == 1 && keys[i].IsEnabled() && found == nil {
+			found = &keys[i]
+		}
+	}
+	return found
+}
+
+type apiKeyContextKey struct{}
+
+// authenticate rejects requests without an enabled API key by the API specific error writer
+func (h *Handler) authenticate(writeErr func(response http.ResponseWriter, request *http.Request, err error)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			apiKeys := h.access.Load().apiKeys
+			if len(apiKeys) == 0 || request.Method == http.MethodOptions {
+				next.ServeHTTP(response, request)
+				return
+			}
+			key := requestAPIKey(request)
+			if len(key) == 0 {
+				writeErr(response, request, newAccessError(http.StatusUnauthorized, "missing API key"))
+			} else if apiKey := findAPIKey(apiKeys, key); apiKey == nil {
+				writeErr(response, request, newAccessError(http.StatusUnauthorized, "invalid API key"))
+			} else {
+				next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), apiKeyContextKey{}, apiKey)))
+			}
+		})
+	}
+}
+
+// authorizeTranslate checks the language pair is allowed for the API key of the authenticated client
+func authorizeTranslate(ctx context.Context, payload *TranslateRequest) error {
+	apiKey, ok := ctx.Value(apiKeyContextKey{}).(*APIKey)
+	if !ok || apiKey.AllowsLanguagePair(payload.SourceLanguageCode, payload.TargetLanguageCode) {
+		return nil
+	}
+	return newAccessError(http.StatusForbidden, "language pair %s is not allowed for the API key %s", languagePair(payload.SourceLanguageCode, payload.TargetLanguageCode), apiKey.Name)
+}
+
+// requestAPIKey extracts the key in the form of any emulated API
+func requestAPIKey(request *http.Request) string {
+	authorization := request.Header.Get("Authorization")
+	for _, scheme := range []string{"Bearer ", "DeepL-Auth-Key ", "Api-Key "} {
+		if strings.HasPrefix(authorization, scheme) {
+			return strings.TrimSpace(authorization[len(scheme):])
+		}
+	}
+	if key := request.Header.Get("X-Goog-Api-Key"); len(key) > 0 {
+		return key
+	}
+	for _, param := range []string{"key", "auth_key", "api_key"} {
+		//FormValue doesn't read JSON bodies
+		if key := request.FormValue(param); len(key) > 0 {
+			return key
+		}
+	}
+	if request.Method == http.MethodPost && isJSONRequest(request) {
+		return jsonBodyAPIKey(request)
+	}
+	return ""
+}
+
+// jsonBodyAPIKey reads the key of a JSON request and restores the body for the handler
+func jsonBodyAPIKey(request *http.Request) string {
+	body, err := io.ReadAll(request.Body)
+	_ = request.Body.Close()
+	request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	payload := new(struct {
+		APIKey  string `json:"api_key"`
+		AuthKey string `json:"auth_key"`
+		Key     string `json:"key"`
+	})
+	if err := json.Unmarshal(body, payload); err != nil {
+		return ""
+	} else if len(payload.APIKey) > 0 {
+		return payload.APIKey
+	} else if len(payload.AuthKey) > 0 {
+		return payload.AuthKey
+	}
+	return payload.Key
+}
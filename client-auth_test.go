@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v2"
+)
+
+func TestAPIKeyEnabledByDefault(t *testing.T) {
+	var config Config
+	if err := yaml.UnmarshalStrict([]byte(`
+apikeys:
+- name: default
+  key: k1
+- name: enabled
+  key: k2
+  enabled: true
+- name: disabled
+  key: k3
+  enabled: false
+`), &config); err != nil {
+		t.Fatal(err)
+	}
+	if err := validateAPIKeys(config.APIKeys); err != nil {
+		t.Fatal(err)
+	}
+	for key, expected := range map[string]string{"k1": "default", "k2": "enabled", "k3": "", "k4": ""} {
+		found := findAPIKey(config.APIKeys, key)
+		if len(expected) == 0 && found != nil {
+			t.Errorf("key %s is accepted as %s", key, found.Name)
+		} else if len(expected) > 0 && (found == nil || found.Name != expected) {
+			t.Errorf("key %s is not accepted as %s", key, expected)
+		}
+	}
+
+	//the absent flag stays absent on write
+	out, err := yaml.Marshal(config.APIKeys[0])
+	if err != nil {
+		t.Fatal(err)
+	} else if strings.Contains(string(out), "enabled") {
+		t.Errorf("marshalled key %q contains the enabled flag", out)
+	}
+}
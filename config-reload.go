@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+	"time"
+)
+
+func NewConfigReloader(file string, yandex *YandexClient, handler *Handler) *ConfigReloader {
+	return &ConfigReloader{file: file, yandex: yandex, handler: handler}
+}
+
+// ConfigReloader applies the changed config file to the running proxy.
+// Credentials and the folder of the default account, API keys, rate limits and glossaries are reloaded, other settings are applied on restart.
+type ConfigReloader struct {
+	file     string
+	yandex   *YandexClient
+	handler  *Handler
+	modified time.Time
+	size     int64
+}
+
+// Run reloads the config on SIGHUP and on the file change detected every interval until the ctx is done
+func (r *ConfigReloader) Run(ctx context.Context, interval time.Duration) {
+	hangup := make(chan os.Signal, 1)
+	signal.Notify(hangup, syscall.SIGHUP)
+	defer signal.Stop(hangup)
+
+	var check <-chan time.Time
+	if interval > 0 {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		check = ticker.C
+	}
+	r.changed()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-hangup:
+			configLog.Info("reload config by SIGHUP", "file", r.file)
+			r.changed()
+			r.reload()
+		case <-check:
+			if r.changed() {
+				configLog.Info("reload changed config", "file", r.file)
+				r.reload()
+			}
+		}
+	}
+}
+
+// changed compares the file modification time and size with the previous check
+func (r *ConfigReloader) changed() bool {
+	info, err := os.Stat(r.file)
+	if err != nil {
+		//the file may be being replaced by an editor
+		return false
+	}
+	changed := !info.ModTime().Equal(r.modified) || info.Size() != r.size
+	r.modified, r.size = info.ModTime(), info.Size()
+	//the token state stored by the proxy is not a config change
+	return changed && !isWrittenConfig(r.file, info)
+}
+
+// reload keeps the current config if the new one is invalid
+func (r *ConfigReloader) reload() {
+	if err := r.Reload(); err != nil {
+		configLog.Error("config reload failed, the current config is kept", err, "file", r.file)
+	}
+}
+
+// Reload reads, validates and applies the config file
+func (r *ConfigReloader) Reload() error {
+	config, err := ReadConfig(r.file)
+	if err != nil {
+		return err
+	}
+	overrideConfig(config)
+	current := r.yandex.Config()
+	//a folder or a token selected at startup is not written to a read-only config file
+	if len(config.OAuthToken) == 0 && len(config.ServiceAccountKeyFile) == 0 {
+		config.OAuthToken, config.ServiceAccountKeyFile = current.OAuthToken, current.ServiceAccountKeyFile
+	}
+	if len(config.FolderID) == 0 {
+		config.FolderID = current.FolderID
+	}
+	if err := validateConfig(config); err != nil {
+		return err
+	} else if len(config.OAuthToken) == 0 && len(config.ServiceAccountKeyFile) == 0 {
+		return errors.New("no OAuth token or service account key file")
+	} else if len(config.FolderID) == 0 {
+		return errors.New("no folder")
+	} else if len(current.APIKeys) > 0 && len(config.APIKeys) == 0 {
+		//an empty or truncated file must not open the proxy to anyone
+		return errors.New("no API keys, authentication is disabled by restart only")
+	} else if current.RateLimits != (RateLimits{}) && config.RateLimits == (RateLimits{}) {
+		return errors.New("no rate limits, rate limiting is disabled by restart only")
+	}
+	glossaries, err := LoadGlossaries(config.Glossaries)
+	if err != nil {
+		return err
+	}
+	//the glossary files are read again even if the config is not changed
+	r.handler.ReloadGlossaries(glossaries)
+	//the token is written by the proxy, the file may contain an older one
+	config.UpdateIamToken(current.IamToken, current.IamTokenExpire)
+	if reflect.DeepEqual(current, *config) {
+		configLog.Debug("config not changed", "file", r.file)
+		return nil
+	}
+	if !reflect.DeepEqual(current.Settings, config.Settings) || current.Translator != config.Translator ||
+		!reflect.DeepEqual(current.Accounts, config.Accounts) || current.Weight != config.Weight || current.Cost != config.Cost {
+		configLog.Warn("changed settings, translator and upstream accounts are applied on restart", "file", r.file)
+	}
+	if err := r.yandex.Reload(*config); err != nil {
+		return err
+	}
+	r.handler.Reload(config.APIKeys, config.RateLimits)
+	configLog.Info("config reloaded", "file", r.file, "folder_id", config.FolderID, "api_keys", len(config.APIKeys))
+	return nil
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/m4gshm/gollections/slice"
+)
+
+const (
+	deepLLanguagesTypeSource = "source"
+	deepLLanguagesTypeTarget = "target"
+)
+
+func (h *Handler) deepLTranslate(response http.ResponseWriter, request *http.Request) {
+	payload := new(DeepLTranslateRequest)
+	if err := decodeDeepLRequest(request, payload); err != nil {
+		writeDeepLError(response, request, err)
+	} else if len(payload.Text) == 0 {
+		writeDeepLError(response, request, newValidationError("parameter 'text' not specified"))
+	} else if len(payload.TargetLang) == 0 {
+		writeDeepLError(response, request, newValidationError("value for 'target_lang' not supported"))
+	} else if format, err := parseDeepLTagHandling(payload.TagHandling); err != nil {
+		writeDeepLError(response, request, err)
+	} else if result, err := h.translate(request.Context(), &TranslateRequest{
+		Texts:              payload.Text,
+		SourceLanguageCode: fromDeepLLanguage(payload.SourceLang),
+		TargetLanguageCode: fromDeepLLanguage(payload.TargetLang),
+		Format:             format,
+	}); err != nil {
+		writeDeepLError(response, request, err)
+	} else {
+		sourceLang := strings.ToUpper(payload.SourceLang)
+		writeJSON(response, http.StatusOK, &DeepLTranslateResponse{
+			Translations: slice.Convert(result.Translations, func(t Translation) DeepLTranslation {
+				detected := sourceLang
+				if len(detected) == 0 {
+					detected = toDeepLLanguage(t.DetectedLanguageCode)
+				}
+				return DeepLTranslation{DetectedSourceLanguage: detected, Text: t.Text}
+			}),
+		})
+	}
+}
+
+func (h *Handler) deepLLanguages(response http.ResponseWriter, request *http.Request) {
+	if err := request.ParseForm(); err != nil {
+		writeDeepLError(response, request, newValidationError("request parse: %w", err))
+	} else if typ := request.Form.Get("type"); len(typ) > 0 && typ != deepLLanguagesTypeSource && typ != deepLLanguagesTypeTarget {
+		writeDeepLError(response, request, newValidationError("value for 'type' not supported: %s", typ))
+	} else if result, err := h.listLanguages(request.Context(), &ListLanguagesRequest{}); err != nil {
+		writeDeepLError(response, request, err)
+	} else {
+		target := typ == deepLLanguagesTypeTarget
+		writeJSON(response, http.StatusOK, slice.Convert(result.Languages, func(l Language) DeepLLanguage {
+			language := DeepLLanguage{Language: toDeepLLanguage(l.Code), Name: l.Name}
+			if target {
+				supportsFormality := false
+				language.SupportsFormality = &supportsFormality
+			}
+			return language
+		}))
+	}
+}
+
+func (h *Handler) deepLUsage(response http.ResponseWriter, request *http.Request) {
+	writeJSON(response, http.StatusOK, &DeepLUsageResponse{
+		CharacterCount: h.counter.TranslatedCharacters(),
+		CharacterLimit: h.characterLimit,
+	})
+}
+
+// decodeDeepLRequest reads parameters from JSON body or from query and form-encoded body
+func decodeDeepLRequest(request *http.Request, payload *DeepLTranslateRequest) error {
+	if isJSONRequest(request) {
+		if err := json.NewDecoder(request.Body).Decode(payload); err != nil {
+			return newValidationError("request unmarshal: %w", err)
+		}
+		return nil
+	}
+	if err := request.ParseForm(); err != nil {
+		return newValidationError("request parse: %w", err)
+	}
+	form := request.Form
+	payload.Text = form["text"]
+	payload.SourceLang = form.Get("source_lang")
+	payload.TargetLang = form.Get("target_lang")
+	payload.TagHandling = form.Get("tag_handling")
+	return nil
+}
+
+func parseDeepLTagHandling(tagHandling string) (string, error) {
+	switch tagHandling {
+	case "":
+		return "", nil
+	case "html":
+		return formatHTML, nil
+	default:
+		return "", newValidationError("value for 'tag_handling' not supported: %s", tagHandling)
+	}
+}
+
+func fromDeepLLanguage(language string) string {
+	return extractLanguage(strings.ToLower(language))
+}
+
+func toDeepLLanguage(language string) string {
+	return strings.ToUpper(language)
+}
+
+func writeDeepLError(response http.ResponseWriter, request *http.Request, err error) {
+	apiErr := writeAPIError(response, request, err)
+	status := apiErr.Status
+	if status == http.StatusUnauthorized {
+		//DeepL rejects an invalid auth key by 403
+		status = http.StatusForbidden
+	}
+	writeJSON(response, status, &DeepLErrorResponse{Message: apiErr.Message})
+}
+
+type DeepLTranslateRequest struct {
+	Text        []string `json:"text"`
+	SourceLang  string   `json:"source_lang"`
+	TargetLang  string   `json:"target_lang"`
+	TagHandling string   `json:"tag_handling"`
+}
+
+type DeepLTranslateResponse struct {
+	Translations []DeepLTranslation `json:"translations"`
+}
+
+type DeepLTranslation struct {
+	DetectedSourceLanguage string `json:"detected_source_language"`
+	Text                   string `json:"text"`
+}
+
+type DeepLLanguage struct {
+	Language          string `json:"language"`
+	Name              string `json:"name"`
+	SupportsFormality *bool  `json:"supports_formality,omitempty"`
+}
+
+type DeepLUsageResponse struct {
+	CharacterCount int64 `json:"character_count"`
+	CharacterLimit int64 `json:"character_limit"`
+}
+
+type DeepLErrorResponse struct {
+	Message string `json:"message"`
+}
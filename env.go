@@ -0,0 +1,35 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+const (
+	envPrefix = "TRANSLATE_PROXY_"
+)
+
+// applyEnv sets flags not passed by the command line from environment variables
+func applyEnv() error {
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	var err error
+	flag.VisitAll(func(f *flag.Flag) {
+		if passed[f.Name] || err != nil {
+			return
+		}
+		env := envName(f.Name)
+		if value, ok := os.LookupEnv(env); ok {
+			if setErr := f.Value.Set(value); setErr != nil {
+				err = fmt.Errorf("environment variable %s: %w", env, setErr)
+			}
+		}
+	})
+	return err
+}
+
+func envName(flagName string) string {
+	return envPrefix + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
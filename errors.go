@@ -0,0 +1,170 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	defaultRetryAfter = "1"
+)
+
+// ValidationError is a mistake in a client's request.
+type ValidationError struct {
+	err error
+}
+
+func (e *ValidationError) Error() string {
+	return e.err.Error()
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.err
+}
+
+var _ error = (*ValidationError)(nil)
+
+func newValidationError(format string, args ...any) error {
+	return &ValidationError{err: fmt.Errorf(format, args...)}
+}
+
+// AccessError rejects a client by the authentication or authorization status.
+type AccessError struct {
+	Status  int
+	message string
+}
+
+func (e *AccessError) Error() string {
+	return e.message
+}
+
+var _ error = (*AccessError)(nil)
+
+func newAccessError(status int, format string, args ...any) error {
+	return &AccessError{Status: status, message: fmt.Sprintf(format, args...)}
+}
+
+// APIError describes an error in terms of the proxy response.
+type APIError struct {
+	Status     int
+	RetryAfter string
+	Message    string
+}
+
+// toAPIError maps client's mistakes to 4xx statuses, upstream failures to 429 and 5xx statuses
+func toAPIError(err error) *APIError {
+	var (
+		validationErr *ValidationError
+		accessErr     *AccessError
+		rateLimitErr  *RateLimitError
+		statusErr     *HTTPStatusError
+		netErr        net.Error
+	)
+	switch {
+	case errors.As(err, &validationErr):
+		return &APIError{Status: http.StatusBadRequest, Message: validationErr.Error()}
+	case errors.As(err, &accessErr):
+		return &APIError{Status: accessErr.Status, Message: accessErr.Error()}
+	case errors.As(err, &rateLimitErr):
+		retryAfter := int(math.Ceil(rateLimitErr.RetryAfter.Seconds()))
+		if retryAfter < 1 {
+			retryAfter = 1
+		}
+		return &APIError{Status: http.StatusTooManyRequests, RetryAfter: strconv.Itoa(retryAfter), Message: rateLimitErr.Error()}
+	case errors.As(err, &statusErr):
+		message := statusErr.Message()
+		switch code := statusErr.Code; {
+		case code == http.StatusBadRequest:
+			return &APIError{Status: http.StatusBadRequest, Message: message}
+		case code == http.StatusTooManyRequests:
+			retryAfter := statusErr.RetryAfter
+			if len(retryAfter) == 0 {
+				retryAfter = defaultRetryAfter
+			}
+			return &APIError{Status: http.StatusTooManyRequests, RetryAfter: retryAfter, Message: message}
+		default:
+			return &APIError{Status: http.StatusBadGateway, Message: "upstream error: " + message}
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		return &APIError{Status: http.StatusGatewayTimeout, Message: "upstream timeout"}
+	case errors.As(err, &netErr):
+		return &APIError{Status: http.StatusBadGateway, Message: "upstream unavailable"}
+	default:
+		return &APIError{Status: http.StatusInternalServerError, Message: "internal error"}
+	}
+}
+
+// grpcCode converts the status to a gRPC code as the Yandex Cloud API does
+func (e *APIError) grpcCode() int {
+	switch e.Status {
+	case http.StatusBadRequest:
+		return 3 //INVALID_ARGUMENT
+	case http.StatusUnauthorized:
+		return 16 //UNAUTHENTICATED
+	case http.StatusForbidden:
+		return 7 //PERMISSION_DENIED
+	case http.StatusTooManyRequests:
+		return 8 //RESOURCE_EXHAUSTED
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return 14 //UNAVAILABLE
+	case http.StatusGatewayTimeout:
+		return 4 //DEADLINE_EXCEEDED
+	default:
+		return 13 //INTERNAL
+	}
+}
+
+// writeAPIError logs the err and writes the response headers of the mapped error
+func writeAPIError(response http.ResponseWriter, request *http.Request, err error) *APIError {
+	apiErr := toAPIError(err)
+	logError(request, apiErr, err)
+	if len(apiErr.RetryAfter) > 0 {
+		response.Header().Set("Retry-After", apiErr.RetryAfter)
+	}
+	if apiErr.Status == http.StatusUnauthorized {
+		response.Header().Set("WWW-Authenticate", "Bearer")
+	}
+	return apiErr
+}
+
+// writeError writes the error in the Yandex Cloud API format
+func writeError(response http.ResponseWriter, request *http.Request, err error) {
+	apiErr := writeAPIError(response, request, err)
+	writeJSON(response, apiErr.Status, &YandexErrorResponse{Code: apiErr.grpcCode(), Message: apiErr.Message})
+}
+
+// logError logs client's mistakes by the warn level, proxy and upstream failures by the error level
+func logError(request *http.Request, apiErr *APIError, err error) {
+	args := []any{"http_method", request.Method, "path", request.URL.Path, "status", apiErr.Status}
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		args = append(args, "upstream_status", statusErr.Code)
+	}
+	if apiErr.Status < http.StatusInternalServerError {
+		serverLog.WarnCtx(request.Context(), "request rejected", append(args, slog.ErrorKey, err)...)
+	} else {
+		serverLog.ErrorCtx(request.Context(), "request failed", err, args...)
+	}
+}
+
+type YandexErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+// upstreamMessage extracts the message field of an upstream error body
+func upstreamMessage(body string) string {
+	payload := new(YandexErrorResponse)
+	if err := json.Unmarshal([]byte(body), payload); err != nil || len(payload.Message) == 0 {
+		return ""
+	}
+	return payload.Message
+}
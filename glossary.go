@@ -0,0 +1,222 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	//upstream limit of glossary pairs in a translate request
+	maxGlossaryPairs = 50
+)
+
+type GlossaryConfig struct {
+	GlossaryData GlossaryData `json:"glossaryData"`
+}
+
+type GlossaryData struct {
+	GlossaryPairs []GlossaryPair `json:"glossaryPairs"`
+}
+
+type GlossaryPair struct {
+	SourceText     string `json:"sourceText"`
+	TranslatedText string `json:"translatedText"`
+	//translate the source text exactly as the translated text, not as a hint
+	Exact bool `json:"exact,omitempty"`
+}
+
+// Glossary is a server-side glossary file attached to translate requests of the language pair
+type Glossary struct {
+	Name string
+	//SRC-DST language codes
+	LanguagePair string
+	//source and translated text pairs, tab separated if the extension is .tsv, comma separated otherwise
+	File          string
+	Exact         bool `yaml:",omitempty"`
+	CaseSensitive bool `yaml:",omitempty"`
+}
+
+// validateGlossaries checks the glossaries config, the files are parsed by LoadGlossaries
+func validateGlossaries(glossaries []Glossary) error {
+	names := map[string]bool{}
+	var errs []error
+	for i, glossary := range glossaries {
+		if len(glossary.Name) == 0 {
+			errs = append(errs, fmt.Errorf("glossary %d: empty name", i))
+		} else if names[glossary.Name] {
+			errs = append(errs, fmt.Errorf("glossary %s: duplicated name", glossary.Name))
+		}
+		names[glossary.Name] = true
+		if _, _, err := splitSrcDestLanguages(glossary.LanguagePair); err != nil {
+			errs = append(errs, fmt.Errorf("glossary %s: %w", glossary.Name, err))
+		}
+		if _, err := os.Stat(glossary.File); err != nil {
+			errs = append(errs, fmt.Errorf("glossary %s: %w", glossary.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// validateGlossaryConfig checks the glossary passed by a client
+func validateGlossaryConfig(config *GlossaryConfig) error {
+	if config == nil {
+		return nil
+	}
+	pairs := config.GlossaryData.GlossaryPairs
+	if len(pairs) == 0 {
+		return newValidationError("invalid parameter: glossaryConfig without glossaryPairs")
+	} else if len(pairs) > maxGlossaryPairs {
+		return newValidationError("invalid parameter: glossaryConfig contains %d pairs, max %d", len(pairs), maxGlossaryPairs)
+	}
+	for i, pair := range pairs {
+		if len(pair.SourceText) == 0 || len(pair.TranslatedText) == 0 {
+			return newValidationError("invalid parameter: glossaryPairs[%d] must contain sourceText and translatedText", i)
+		}
+	}
+	return nil
+}
+
+// Glossaries are server-side glossaries by language pair
+type Glossaries struct {
+	byLanguagePair map[string][]*loadedGlossary
+}
+
+type loadedGlossary struct {
+	pairs []GlossaryPair
+	//case insensitive source text patterns by pair index, nil for a case sensitive glossary
+	patterns []*regexp.Regexp
+}
+
+// LoadGlossaries reads the glossary files
+func LoadGlossaries(glossaries []Glossary) (*Glossaries, error) {
+	loaded := &Glossaries{byLanguagePair: map[string][]*loadedGlossary{}}
+	for _, glossary := range glossaries {
+		pairs, err := readGlossaryFile(glossary.File, glossary.Exact)
+		if err != nil {
+			return nil, fmt.Errorf("glossary %s: %w", glossary.Name, err)
+		}
+		g := &loadedGlossary{pairs: pairs}
+		if !glossary.CaseSensitive {
+			g.patterns = make([]*regexp.Regexp, len(pairs))
+			for i, pair := range pairs {
+				g.patterns[i] = regexp.MustCompile("(?i)" + regexp.QuoteMeta(pair.SourceText))
+			}
+		}
+		source, target, _ := splitSrcDestLanguages(glossary.LanguagePair)
+		key := strings.ToLower(languagePair(source, target))
+		loaded.byLanguagePair[key] = append(loaded.byLanguagePair[key], g)
+		configLog.Info("glossary loaded", "name", glossary.Name, "language_pair", key, "pairs", len(pairs))
+	}
+	return loaded, nil
+}
+
+func readGlossaryFile(file string, exact bool) ([]GlossaryPair, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = f.Close() }()
+	reader := csv.NewReader(f)
+	if ext := strings.ToLower(filepath.Ext(file)); ext == ".tsv" || ext == ".tab" {
+		reader.Comma = '\t'
+		reader.LazyQuotes = true
+	}
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	var pairs []GlossaryPair
+	sources := map[string]bool{}
+	for {
+		record, err := reader.Read()
+		if errors.Is(err, io.EOF) {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+		line, _ := reader.FieldPos(0)
+		source, translated := strings.TrimSpace(record[0]), strings.TrimSpace(record[1])
+		if len(source) == 0 || len(translated) == 0 {
+			return nil, fmt.Errorf("%s:%d: empty source or translated text", file, line)
+		} else if sources[source] {
+			return nil, fmt.Errorf("%s:%d: duplicated source text %q", file, line, source)
+		}
+		sources[source] = true
+		pairs = append(pairs, GlossaryPair{SourceText: source, TranslatedText: translated, Exact: exact})
+	}
+	return pairs, nil
+}
+
+// attach adds the pairs of the language pair glossaries found in the texts to the client's ones.
+// A case insensitive pair is added for every spelling found because upstream matches the source text as is.
+func (g *Glossaries) attach(ctx context.Context, request *TranslateRequest) {
+	if g == nil || len(request.SourceLanguageCode) == 0 {
+		//upstream applies glossaries only to requests with the source language
+		return
+	}
+	glossaries := g.byLanguagePair[strings.ToLower(languagePair(request.SourceLanguageCode, request.TargetLanguageCode))]
+	if len(glossaries) == 0 {
+		return
+	}
+	var pairs []GlossaryPair
+	sources := map[string]bool{}
+	if request.GlossaryConfig != nil {
+		pairs = append(pairs, request.GlossaryConfig.GlossaryData.GlossaryPairs...)
+		//client's pairs win
+		for _, pair := range pairs {
+			sources[pair.SourceText] = true
+		}
+	}
+	attached, dropped := 0, 0
+	add := func(pair GlossaryPair) {
+		if sources[pair.SourceText] {
+			return
+		} else if len(pairs) >= maxGlossaryPairs {
+			dropped++
+			return
+		}
+		sources[pair.SourceText] = true
+		pairs = append(pairs, pair)
+		attached++
+	}
+	for _, glossary := range glossaries {
+		for i, pair := range glossary.pairs {
+			for _, text := range request.Texts {
+				if glossary.patterns == nil {
+					if strings.Contains(text, pair.SourceText) {
+						add(pair)
+					}
+				} else {
+					for _, spelling := range glossary.patterns[i].FindAllString(text, -1) {
+						add(GlossaryPair{SourceText: spelling, TranslatedText: pair.TranslatedText, Exact: pair.Exact})
+					}
+				}
+			}
+		}
+	}
+	if dropped > 0 {
+		serverLog.WarnCtx(ctx, "glossary pairs over the request limit are dropped", "dropped", dropped, "limit", maxGlossaryPairs)
+	}
+	if attached > 0 {
+		request.GlossaryConfig = &GlossaryConfig{GlossaryData: GlossaryData{GlossaryPairs: pairs}}
+		serverLog.DebugCtx(ctx, "glossary pairs attached", "pairs", attached)
+	}
+}
+
+// glossaryHash identifies the glossary of the request for caches and batches, empty if there is no glossary
+func glossaryHash(request *TranslateRequest) string {
+	if request.GlossaryConfig == nil {
+		return ""
+	}
+	payload, _ := json.Marshal(request.GlossaryConfig)
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
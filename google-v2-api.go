@@ -0,0 +1,219 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/m4gshm/gollections/slice"
+)
+
+func (h *Handler) googleTranslate(response http.ResponseWriter, request *http.Request) {
+	payload := new(GoogleRequest)
+	if err := decodeGoogleRequest(request, payload); err != nil {
+		writeGoogleError(response, request, err)
+	} else if len(payload.Q) == 0 {
+		writeGoogleError(response, request, newValidationError("required parameter: q"))
+	} else if len(payload.Target) == 0 {
+		writeGoogleError(response, request, newValidationError("required parameter: target"))
+	} else if format, err := parseGoogleFormat(payload.Format); err != nil {
+		writeGoogleError(response, request, err)
+	} else if result, err := h.translate(request.Context(), &TranslateRequest{
+		Texts:              payload.Q,
+		SourceLanguageCode: extractLanguage(payload.Source),
+		TargetLanguageCode: extractLanguage(payload.Target),
+		Format:             format,
+	}); err != nil {
+		writeGoogleError(response, request, err)
+	} else {
+		detected := len(payload.Source) == 0
+		writeJSON(response, http.StatusOK, &GoogleResponse[GoogleTranslations]{Data: GoogleTranslations{
+			Translations: slice.Convert(result.Translations, func(t Translation) GoogleTranslation {
+				translation := GoogleTranslation{TranslatedText: t.Text}
+				if detected {
+					translation.DetectedSourceLanguage = t.DetectedLanguageCode
+				}
+				return translation
+			}),
+		}})
+	}
+}
+
+func (h *Handler) googleDetect(response http.ResponseWriter, request *http.Request) {
+	payload := new(GoogleRequest)
+	if err := decodeGoogleRequest(request, payload); err != nil {
+		writeGoogleError(response, request, err)
+		return
+	} else if len(payload.Q) == 0 {
+		writeGoogleError(response, request, newValidationError("required parameter: q"))
+		return
+	}
+	detections := make([][]GoogleDetection, 0, len(payload.Q))
+	for _, text := range payload.Q {
+		result, err := h.detect(request.Context(), &DetectRequest{Text: text})
+		if err != nil {
+			writeGoogleError(response, request, err)
+			return
+		}
+		detections = append(detections, []GoogleDetection{{Language: result.LanguageCode, Confidence: 1}})
+	}
+	writeJSON(response, http.StatusOK, &GoogleResponse[GoogleDetections]{Data: GoogleDetections{Detections: detections}})
+}
+
+func (h *Handler) googleLanguages(response http.ResponseWriter, request *http.Request) {
+	payload := new(GoogleRequest)
+	if err := decodeGoogleRequest(request, payload); err != nil {
+		writeGoogleError(response, request, err)
+	} else if result, err := h.listLanguages(request.Context(), &ListLanguagesRequest{}); err != nil {
+		writeGoogleError(response, request, err)
+	} else {
+		withNames := len(payload.Target) > 0
+		writeJSON(response, http.StatusOK, &GoogleResponse[GoogleLanguages]{Data: GoogleLanguages{
+			Languages: slice.Convert(result.Languages, func(l Language) GoogleLanguage {
+				language := GoogleLanguage{Language: l.Code}
+				if withNames {
+					language.Name = l.Name
+				}
+				return language
+			}),
+		}})
+	}
+}
+
+// decodeGoogleRequest reads parameters from JSON body or from query and form-encoded body
+func decodeGoogleRequest(request *http.Request, payload *GoogleRequest) error {
+	if isJSONRequest(request) {
+		if err := json.NewDecoder(request.Body).Decode(payload); err != nil {
+			return newValidationError("request unmarshal: %w", err)
+		}
+	}
+	if err := request.ParseForm(); err != nil {
+		return newValidationError("request parse: %w", err)
+	}
+	form := request.Form
+	payload.Q = append(payload.Q, form["q"]...)
+	if source := form.Get("source"); len(source) > 0 {
+		payload.Source = source
+	}
+	if target := form.Get("target"); len(target) > 0 {
+		payload.Target = target
+	}
+	if format := form.Get("format"); len(format) > 0 {
+		payload.Format = format
+	}
+	return nil
+}
+
+func parseGoogleFormat(format string) (string, error) {
+	switch format {
+	case "", "text":
+		return "", nil
+	case "html":
+		return formatHTML, nil
+	default:
+		return "", newValidationError("invalid value: format %s", format)
+	}
+}
+
+func writeGoogleError(response http.ResponseWriter, request *http.Request, err error) {
+	apiErr := writeAPIError(response, request, err)
+	status, reason := googleErrorStatus(apiErr.Status)
+	writeJSON(response, apiErr.Status, &GoogleErrorResponse{Error: GoogleError{
+		Code:    apiErr.Status,
+		Message: apiErr.Message,
+		Errors:  []GoogleErrorItem{{Message: apiErr.Message, Domain: "global", Reason: reason}},
+		Status:  status,
+	}})
+}
+
+func googleErrorStatus(code int) (string, string) {
+	switch code {
+	case http.StatusBadRequest:
+		return "INVALID_ARGUMENT", "invalid"
+	case http.StatusUnauthorized:
+		return "UNAUTHENTICATED", "unauthorized"
+	case http.StatusForbidden:
+		return "PERMISSION_DENIED", "forbidden"
+	case http.StatusTooManyRequests:
+		return "RESOURCE_EXHAUSTED", "rateLimitExceeded"
+	case http.StatusBadGateway, http.StatusServiceUnavailable:
+		return "UNAVAILABLE", "backendError"
+	case http.StatusGatewayTimeout:
+		return "DEADLINE_EXCEEDED", "backendError"
+	default:
+		return "INTERNAL", "backendError"
+	}
+}
+
+type GoogleRequest struct {
+	Q      GoogleStrings `json:"q"`
+	Source string        `json:"source"`
+	Target string        `json:"target"`
+	Format string        `json:"format"`
+	Model  string        `json:"model"`
+}
+
+// GoogleStrings accepts a single string or an array of strings
+type GoogleStrings []string
+
+func (s *GoogleStrings) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*s = []string{single}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*s = multiple
+	return nil
+}
+
+type GoogleResponse[T any] struct {
+	Data T `json:"data"`
+}
+
+type GoogleTranslations struct {
+	Translations []GoogleTranslation `json:"translations"`
+}
+
+type GoogleTranslation struct {
+	TranslatedText         string `json:"translatedText"`
+	DetectedSourceLanguage string `json:"detectedSourceLanguage,omitempty"`
+}
+
+type GoogleDetections struct {
+	Detections [][]GoogleDetection `json:"detections"`
+}
+
+type GoogleDetection struct {
+	Language   string  `json:"language"`
+	IsReliable bool    `json:"isReliable"`
+	Confidence float64 `json:"confidence"`
+}
+
+type GoogleLanguages struct {
+	Languages []GoogleLanguage `json:"languages"`
+}
+
+type GoogleLanguage struct {
+	Language string `json:"language"`
+	Name     string `json:"name,omitempty"`
+}
+
+type GoogleErrorResponse struct {
+	Error GoogleError `json:"error"`
+}
+
+type GoogleError struct {
+	Code    int               `json:"code"`
+	Message string            `json:"message"`
+	Errors  []GoogleErrorItem `json:"errors"`
+	Status  string            `json:"status"`
+}
+
+type GoogleErrorItem struct {
+	Message string `json:"message"`
+	Domain  string `json:"domain"`
+	Reason  string `json:"reason"`
+}
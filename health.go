@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/exp/slices"
+)
+
+const (
+	//the folder lookup is an upstream call, so its result is reused by next probes
+	folderCheckInterval = time.Minute
+	//a probe must answer before the orchestrator gives up on it
+	folderCheckTimeout = 2 * time.Second
+	//upstream is considered failing after so many failed calls in a row
+	upstreamFailuresThreshold = 3
+	folderStatusActive        = "ACTIVE"
+)
+
+// upstreamHealth tracks outcomes of all Yandex Cloud API calls
+var upstreamHealth = new(upstreamTracker)
+
+type upstreamTracker struct {
+	mu                  sync.Mutex
+	consecutiveFailures int
+	lastSuccess         time.Time
+	lastFailure         time.Time
+	lastError           string
+}
+
+// record treats responses to client mistakes as successful calls, the upstream is available for them
+func (t *upstreamTracker) record(status int, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err == nil && status < http.StatusInternalServerError && status != http.StatusUnauthorized && status != http.StatusTooManyRequests {
+		t.consecutiveFailures = 0
+		t.lastSuccess = time.Now()
+		return
+	}
+	t.consecutiveFailures++
+	t.lastFailure = time.Now()
+	if err != nil {
+		t.lastError = err.Error()
+	} else {
+		t.lastError = fmt.Sprintf("status %d", status)
+	}
+}
+
+func (t *upstreamTracker) check() UpstreamCheck {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	check := UpstreamCheck{
+		Check:               Check{OK: t.consecutiveFailures < upstreamFailuresThreshold},
+		ConsecutiveFailures: t.consecutiveFailures,
+		LastSuccess:         t.lastSuccess,
+		LastFailure:         t.lastFailure,
+	}
+	if t.consecutiveFailures > 0 {
+		check.Error = t.lastError
+	}
+	return check
+}
+
+func NewHealthChecker(yandex *YandexClient, pool *UpstreamPool) *HealthChecker {
+	return &HealthChecker{yandex: yandex, pool: pool}
+}
+
+// HealthChecker answers orchestrator probes
+type HealthChecker struct {
+	yandex *YandexClient
+	//nil means the only account of the yandex client
+	pool *UpstreamPool
+	//set by the shutdown to stop traffic routing before the listener is closed
+	draining      atomic.Bool
+	folderMu      sync.Mutex
+	folderCheck   Check
+	folderChecked time.Time
+	//a probe checking the folder, others get the cached result meanwhile
+	folderChecking bool
+	//the folder of the cached check, a reloaded config may change it
+	checkedFolderID string
+}
+
+type Readiness struct {
+	Ready    bool           `json:"ready"`
+	Draining bool           `json:"draining,omitempty"`
+	IamToken IamTokenCheck  `json:"iamToken"`
+	Folder   FolderCheck    `json:"folder"`
+	Upstream UpstreamCheck  `json:"upstream"`
+	Accounts []AccountState `json:"accounts,omitempty"`
+}
+
+type Check struct {
+	OK    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+type IamTokenCheck struct {
+	Check
+	State IamTokenState `json:"state"`
+}
+
+type FolderCheck struct {
+	Check
+	FolderID  string    `json:"folderId"`
+	CheckedAt time.Time `json:"checkedAt"`
+}
+
+type UpstreamCheck struct {
+	Check
+	ConsecutiveFailures int       `json:"consecutiveFailures"`
+	LastSuccess         time.Time `json:"lastSuccess"`
+	LastFailure         time.Time `json:"lastFailure"`
+}
+
+// healthz reports the process is up
+func healthz(response http.ResponseWriter, _ *http.Request) {
+	response.WriteHeader(http.StatusOK)
+	_, _ = response.Write([]byte("ok"))
+}
+
+// Readyz reports whether the proxy can translate, 503 means not ready
+func (c *HealthChecker) Readyz(response http.ResponseWriter, _ *http.Request) {
+	readiness := c.Readiness()
+	status := http.StatusOK
+	if !readiness.Ready {
+		status = http.StatusServiceUnavailable
+	}
+	writeJSON(response, status, readiness)
+}
+
+// Drain makes the proxy not ready for the rest of its life
+func (c *HealthChecker) Drain() {
+	c.draining.Store(true)
+}
+
+func (c *HealthChecker) Readiness() *Readiness {
+	readiness := &Readiness{Draining: c.draining.Load(), IamToken: c.checkIamToken(), Folder: c.checkFolder(), Upstream: upstreamHealth.check()}
+	readiness.Ready = !readiness.Draining && readiness.Upstream.OK
+	if c.pool != nil {
+		readiness.Accounts = c.pool.States()
+	}
+	if len(readiness.Accounts) > 1 {
+		//the checks of the default account are informational, another account can serve calls
+		readiness.Ready = readiness.Ready && slices.ContainsFunc(readiness.Accounts, func(a AccountState) bool { return a.Available && a.IamTokenOK })
+	} else {
+		readiness.Ready = readiness.Ready && readiness.IamToken.OK && readiness.Folder.OK
+	}
+	return readiness
+}
+
+func (c *HealthChecker) checkIamToken() IamTokenCheck {
+	state := c.yandex.IamTokenState()
+	check := IamTokenCheck{Check: Check{OK: state.Valid}, State: state}
+	if !state.Valid {
+		check.Error = "no valid IAM token"
+		if len(state.LastError) > 0 {
+			check.Error += ": " + state.LastError
+		}
+	}
+	return check
+}
+
+// checkFolder refreshes the stale cached result by one probe at a time without holding the lock during the upstream call
+func (c *HealthChecker) checkFolder() FolderCheck {
+	folderID := c.yandex.Config().FolderID
+	c.folderMu.Lock()
+	stale := time.Since(c.folderChecked) >= folderCheckInterval || folderID != c.checkedFolderID
+	if !stale || c.folderChecking {
+		check := FolderCheck{Check: c.folderCheck, FolderID: c.checkedFolderID, CheckedAt: c.folderChecked}
+		c.folderMu.Unlock()
+		if folderID != check.FolderID {
+			check = FolderCheck{Check: Check{Error: "folder check in progress"}, FolderID: folderID}
+		}
+		return check
+	}
+	c.folderChecking = true
+	c.folderMu.Unlock()
+
+	//a gone prober does not cancel the check, its result is cached for others
+	ctx, cancel := context.WithTimeout(context.Background(), folderCheckTimeout)
+	defer cancel()
+	check := c.resolveFolder(ctx, folderID)
+	checked := time.Now()
+
+	c.folderMu.Lock()
+	defer c.folderMu.Unlock()
+	c.folderChecking = false
+	c.folderCheck, c.folderChecked, c.checkedFolderID = check, checked, folderID
+	return FolderCheck{Check: check, FolderID: folderID, CheckedAt: checked}
+}
+
+func (c *HealthChecker) resolveFolder(ctx context.Context, folderID string) Check {
+	if len(folderID) == 0 {
+		return Check{Error: "no folder configured"}
+	} else if folder, err := c.yandex.getCloudFolder(ctx, folderID, noRetry); err != nil {
+		return Check{Error: err.Error()}
+	} else if folder.Status != folderStatusActive {
+		return Check{Error: fmt.Sprintf("folder status %s", folder.Status)}
+	}
+	return Check{OK: true}
+}
@@ -0,0 +1,224 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+const (
+	iamTokenRetryInterval = time.Minute
+	//a refresh is shared by concurrent callers, so it is not bound to a caller's context but to this timeout
+	iamTokenRequestTimeout = 30 * time.Second
+)
+
+// IamTokenManager shares one IAM token between concurrent requests.
+// Concurrent refreshes are collapsed into one upstream call, the token is refreshed in background before it expires.
+type IamTokenManager struct {
+	mu            sync.Mutex
+	storeMu       sync.Mutex
+	config        *Config
+	request       func(ctx context.Context) (*IamTokenResponse, error)
+	store         func(config Config)
+	refreshBefore time.Duration
+	inFlight      *iamTokenRefresh
+	state         IamTokenState
+	//incremented by every credentials change to drop tokens of the previous credentials
+	generation int
+}
+
+type iamTokenRefresh struct {
+	done       chan struct{}
+	generation int
+	token      string
+	err        error
+}
+
+// IamTokenState describes the token manager state for monitoring
+type IamTokenState struct {
+	Valid       bool      `json:"valid"`
+	ExpiresAt   time.Time `json:"expiresAt"`
+	Refreshing  bool      `json:"refreshing"`
+	LastRefresh time.Time `json:"lastRefresh"`
+	LastFailure time.Time `json:"lastFailure"`
+	LastError   string    `json:"lastError,omitempty"`
+	Refreshes   int64     `json:"refreshes"`
+	Failures    int64     `json:"failures"`
+}
+
+func NewIamTokenManager(config *Config, request func(ctx context.Context) (*IamTokenResponse, error), store func(config Config), refreshBefore time.Duration) *IamTokenManager {
+	return &IamTokenManager{config: config, request: request, store: store, refreshBefore: refreshBefore}
+}
+
+// Token returns the current token or waits for a new one until the ctx is done if the current has expired
+func (m *IamTokenManager) Token(ctx context.Context) (string, error) {
+	m.mu.Lock()
+	if !m.config.IsIamTokenExpired() {
+		token := m.config.IamToken
+		m.mu.Unlock()
+		return token, nil
+	}
+	refresh := m.startRefresh()
+	m.mu.Unlock()
+	return refresh.wait(ctx)
+}
+
+// Refresh requests a token instead of the rejected one unless another refresh has already replaced it, waits until the ctx is done
+func (m *IamTokenManager) Refresh(ctx context.Context, rejected string) (string, error) {
+	m.mu.Lock()
+	if token := m.config.IamToken; token != rejected && !m.config.IsIamTokenExpired() {
+		m.mu.Unlock()
+		return token, nil
+	}
+	refresh := m.startRefresh()
+	m.mu.Unlock()
+	return refresh.wait(ctx)
+}
+
+func (r *iamTokenRefresh) wait(ctx context.Context) (string, error) {
+	select {
+	case <-ctx.Done():
+		//the refresh goes on for next callers
+		return "", ctx.Err()
+	case <-r.done:
+		return r.token, r.err
+	}
+}
+
+// Config returns a snapshot of the config
+func (m *IamTokenManager) Config() Config {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return *m.config
+}
+
+// Reload swaps the config, the token of the new credentials replaces the current one if it is passed
+func (m *IamTokenManager) Reload(config Config, token *IamTokenResponse) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if token != nil {
+		config.UpdateIamToken(token.IamToken, token.ExpiresAt)
+		m.generation++
+	} else {
+		config.UpdateIamToken(m.config.IamToken, m.config.IamTokenExpire)
+	}
+	*m.config = config
+}
+
+func (m *IamTokenManager) State() IamTokenState {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	state := m.state
+	state.Valid = !m.config.IsIamTokenExpired()
+	state.ExpiresAt = m.config.IamTokenExpire
+	return state
+}
+
+// Run refreshes the token before it expires until the ctx is done
+func (m *IamTokenManager) Run(ctx context.Context) {
+	for {
+		timer := time.NewTimer(m.nextRefreshIn())
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+		m.mu.Lock()
+		refresh := m.startRefresh()
+		m.mu.Unlock()
+		<-refresh.done
+		if refresh.err != nil {
+			yandexLog.Error("background IAM token refresh", refresh.err)
+		}
+	}
+}
+
+func (m *IamTokenManager) nextRefreshIn() time.Duration {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.state.LastFailure.After(m.state.LastRefresh) {
+		return iamTokenRetryInterval
+	}
+	wait := time.Until(m.config.IamTokenExpire.Add(-m.refreshBefore))
+	if wait < iamTokenRetryInterval {
+		//prevents a busy loop when the token lifetime is shorter than refreshBefore
+		return iamTokenRetryInterval
+	}
+	return wait
+}
+
+// startRefresh must be called under the lock
+func (m *IamTokenManager) startRefresh() *iamTokenRefresh {
+	if m.inFlight != nil {
+		return m.inFlight
+	}
+	refresh := &iamTokenRefresh{done: make(chan struct{}), generation: m.generation}
+	m.inFlight = refresh
+	m.state.Refreshing = true
+	go m.refresh(refresh)
+	return refresh
+}
+
+func (m *IamTokenManager) refresh(refresh *iamTokenRefresh) {
+	ctx, cancel := context.WithTimeout(context.Background(), iamTokenRequestTimeout)
+	resp, err := m.request(ctx)
+	cancel()
+
+	m.mu.Lock()
+	m.inFlight = nil
+	m.state.Refreshing = false
+	if err != nil {
+		refresh.err = fmt.Errorf("request IAM token: %w", err)
+		m.state.Failures++
+		m.state.LastFailure = time.Now()
+		m.state.LastError = err.Error()
+		m.mu.Unlock()
+		iamTokenRefreshes.WithLabelValues("failure").Inc()
+		close(refresh.done)
+		return
+	}
+	if refresh.generation == m.generation {
+		m.config.UpdateIamToken(resp.IamToken, resp.ExpiresAt)
+	}
+	refresh.token = resp.IamToken
+	m.state.Refreshes++
+	m.state.LastRefresh = time.Now()
+	m.state.LastError = ""
+	m.mu.Unlock()
+	iamTokenRefreshes.WithLabelValues("success").Inc()
+	close(refresh.done)
+
+	m.Store()
+}
+
+// Store writes the token state if the config is writeable
+func (m *IamTokenManager) Store() {
+	if m.store == nil {
+		return
+	}
+	m.storeMu.Lock()
+	defer m.storeMu.Unlock()
+	//takes the snapshot under the store lock to never overwrite a newer token by an older one
+	m.mu.Lock()
+	snapshot := *m.config
+	m.mu.Unlock()
+	m.store(snapshot)
+}
+
+// Flush waits for the refresh in progress until the ctx is done and stores the token state
+func (m *IamTokenManager) Flush(ctx context.Context) {
+	m.mu.Lock()
+	refresh := m.inFlight
+	m.mu.Unlock()
+	if refresh != nil {
+		select {
+		case <-refresh.done:
+		case <-ctx.Done():
+			yandexLog.Warn("IAM token refresh is interrupted by the shutdown")
+			return
+		}
+	}
+	m.Store()
+}
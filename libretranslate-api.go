@@ -0,0 +1,181 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/m4gshm/gollections/slice"
+)
+
+const (
+	libreTranslateAutoLanguage = "auto"
+	//the engine doesn't return a detection confidence
+	libreTranslateConfidence = 100
+)
+
+func (h *Handler) libreTranslateTranslate(response http.ResponseWriter, request *http.Request) {
+	payload := new(LibreTranslateRequest)
+	if err := decodeLibreTranslateRequest(request, payload); err != nil {
+		writeLibreTranslateError(response, request, err)
+	} else if len(payload.Q.Texts) == 0 {
+		writeLibreTranslateError(response, request, newValidationError("invalid request: missing q parameter"))
+	} else if len(payload.Target) == 0 {
+		writeLibreTranslateError(response, request, newValidationError("invalid request: missing target parameter"))
+	} else if format, err := parseLibreTranslateFormat(payload.Format); err != nil {
+		writeLibreTranslateError(response, request, err)
+	} else {
+		source := payload.Source
+		if source == libreTranslateAutoLanguage {
+			source = ""
+		}
+		result, err := h.translate(request.Context(), &TranslateRequest{
+			Texts:              payload.Q.Texts,
+			SourceLanguageCode: extractLanguage(source),
+			TargetLanguageCode: extractLanguage(payload.Target),
+			Format:             format,
+		})
+		if err != nil {
+			writeLibreTranslateError(response, request, err)
+			return
+		}
+		detect := len(source) == 0
+		texts := slice.Convert(result.Translations, func(t Translation) string { return t.Text })
+		detected := slice.Convert(result.Translations, func(t Translation) LibreTranslateDetection {
+			return LibreTranslateDetection{Confidence: libreTranslateConfidence, Language: t.DetectedLanguageCode}
+		})
+		if payload.Q.Array {
+			resp := &LibreTranslateMultipleResponse{TranslatedText: texts}
+			if detect {
+				resp.DetectedLanguage = detected
+			}
+			writeJSON(response, http.StatusOK, resp)
+		} else if len(texts) != 1 {
+			writeLibreTranslateError(response, request, fmt.Errorf("unexpected translations amount %d", len(texts)))
+		} else {
+			resp := &LibreTranslateResponse{TranslatedText: texts[0]}
+			if detect {
+				resp.DetectedLanguage = &detected[0]
+			}
+			writeJSON(response, http.StatusOK, resp)
+		}
+	}
+}
+
+func (h *Handler) libreTranslateDetect(response http.ResponseWriter, request *http.Request) {
+	payload := new(LibreTranslateRequest)
+	if err := decodeLibreTranslateRequest(request, payload); err != nil {
+		writeLibreTranslateError(response, request, err)
+	} else if len(payload.Q.Texts) == 0 {
+		writeLibreTranslateError(response, request, newValidationError("invalid request: missing q parameter"))
+	} else if result, err := h.detect(request.Context(), &DetectRequest{Text: payload.Q.Texts[0]}); err != nil {
+		writeLibreTranslateError(response, request, err)
+	} else {
+		writeJSON(response, http.StatusOK, []LibreTranslateDetection{{Confidence: libreTranslateConfidence, Language: result.LanguageCode}})
+	}
+}
+
+func (h *Handler) libreTranslateLanguages(response http.ResponseWriter, request *http.Request) {
+	if result, err := h.listLanguages(request.Context(), &ListLanguagesRequest{}); err != nil {
+		writeLibreTranslateError(response, request, err)
+	} else {
+		codes := slice.Convert(result.Languages, func(l Language) string { return l.Code })
+		writeJSON(response, http.StatusOK, slice.Convert(result.Languages, func(l Language) LibreTranslateLanguage {
+			return LibreTranslateLanguage{
+				Code:    l.Code,
+				Name:    l.Name,
+				Targets: slice.Filter(codes, func(code string) bool { return code != l.Code }),
+			}
+		}))
+	}
+}
+
+// decodeLibreTranslateRequest reads parameters from JSON body or from query and form-encoded body
+func decodeLibreTranslateRequest(request *http.Request, payload *LibreTranslateRequest) error {
+	if isJSONRequest(request) {
+		if err := json.NewDecoder(request.Body).Decode(payload); err != nil {
+			return newValidationError("request unmarshal: %w", err)
+		}
+		return nil
+	}
+	if err := request.ParseForm(); err != nil {
+		return newValidationError("request parse: %w", err)
+	}
+	form := request.Form
+	q := form["q"]
+	payload.Q = LibreTranslateQ{Texts: q, Array: len(q) > 1}
+	payload.Source = form.Get("source")
+	payload.Target = form.Get("target")
+	payload.Format = form.Get("format")
+	payload.APIKey = form.Get("api_key")
+	return nil
+}
+
+func parseLibreTranslateFormat(format string) (string, error) {
+	switch format {
+	case "", "text":
+		return "", nil
+	case "html":
+		return formatHTML, nil
+	default:
+		return "", newValidationError("invalid request: unsupported format %s", format)
+	}
+}
+
+func writeLibreTranslateError(response http.ResponseWriter, request *http.Request, err error) {
+	apiErr := writeAPIError(response, request, err)
+	writeJSON(response, apiErr.Status, &LibreTranslateErrorResponse{Error: apiErr.Message})
+}
+
+type LibreTranslateRequest struct {
+	Q      LibreTranslateQ `json:"q"`
+	Source string          `json:"source"`
+	Target string          `json:"target"`
+	Format string          `json:"format"`
+	APIKey string          `json:"api_key"`
+}
+
+// LibreTranslateQ is a single text or an array of texts, the response shape follows it
+type LibreTranslateQ struct {
+	Texts []string
+	Array bool
+}
+
+func (q *LibreTranslateQ) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*q = LibreTranslateQ{Texts: []string{single}}
+		return nil
+	}
+	var multiple []string
+	if err := json.Unmarshal(data, &multiple); err != nil {
+		return err
+	}
+	*q = LibreTranslateQ{Texts: multiple, Array: true}
+	return nil
+}
+
+type LibreTranslateResponse struct {
+	TranslatedText   string                   `json:"translatedText"`
+	DetectedLanguage *LibreTranslateDetection `json:"detectedLanguage,omitempty"`
+}
+
+type LibreTranslateMultipleResponse struct {
+	TranslatedText   []string                  `json:"translatedText"`
+	DetectedLanguage []LibreTranslateDetection `json:"detectedLanguage,omitempty"`
+}
+
+type LibreTranslateDetection struct {
+	Confidence float64 `json:"confidence"`
+	Language   string  `json:"language"`
+}
+
+type LibreTranslateLanguage struct {
+	Code    string   `json:"code"`
+	Name    string   `json:"name"`
+	Targets []string `json:"targets"`
+}
+
+type LibreTranslateErrorResponse struct {
+	Error string `json:"error"`
+}
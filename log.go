@@ -1,15 +1,170 @@
 package main
 
-import "log"
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"os"
+	"strings"
+	"time"
 
-func logError(err error) {
-	log.Printf("ERROR %s\n", err.Error())
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+var logLevel = new(slog.LevelVar)
+
+// subsystem loggers, they are replaced by setupLogging
+var (
+	serverLog = newSubsystemLogger(slog.Default().Handler(), "server")
+	yandexLog = newSubsystemLogger(slog.Default().Handler(), "yandex")
+	configLog = newSubsystemLogger(slog.Default().Handler(), "config")
+	cacheLog  = newSubsystemLogger(slog.Default().Handler(), "cache")
+)
+
+// setupLogging configures the output format (text or json) and the minimal level (debug, info, warn or error)
+func setupLogging(format, level string) error {
+	if err := logLevel.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("log level %s: %w", level, err)
+	}
+	options := slog.HandlerOptions{Level: logLevel}
+	var handler slog.Handler
+	switch format {
+	case logFormatText:
+		handler = options.NewTextHandler(os.Stderr)
+	case logFormatJSON:
+		handler = options.NewJSONHandler(os.Stderr)
+	default:
+		return fmt.Errorf("unsupported log format %s", format)
+	}
+	slog.SetDefault(slog.New(contextHandler{handler}))
+	serverLog = newSubsystemLogger(handler, "server")
+	yandexLog = newSubsystemLogger(handler, "yandex")
+	configLog = newSubsystemLogger(handler, "config")
+	cacheLog = newSubsystemLogger(handler, "cache")
+	return nil
+}
+
+func newSubsystemLogger(handler slog.Handler, subsystem string) *slog.Logger {
+	return slog.New(contextHandler{handler}).With("subsystem", subsystem)
+}
+
+// contextHandler adds the request ID and the batch ID of the context to every record
+type contextHandler struct {
+	slog.Handler
+}
+
+func (h contextHandler) Handle(ctx context.Context, record slog.Record) error {
+	if ctx != nil {
+		if requestID := middleware.GetReqID(ctx); len(requestID) > 0 {
+			record.AddAttrs(slog.String("request_id", requestID))
+		}
+		if batchID, ok := ctx.Value(batchIDKey{}).(uint64); ok {
+			record.AddAttrs(slog.Uint64("batch_id", batchID))
+		}
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h contextHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	return contextHandler{h.Handler.WithAttrs(attrs)}
+}
+
+func (h contextHandler) WithGroup(name string) slog.Handler {
+	return contextHandler{h.Handler.WithGroup(name)}
+}
+
+type payloadSampledKey struct{}
+
+// withPayloadSampling decides once for the request and its response whether the payloads are logged in full
+func withPayloadSampling(ctx context.Context) context.Context {
+	sampled := !*logPrivacy && *logPayloadSampleRate > 0 && rand.Float64() < *logPayloadSampleRate
+	return context.WithValue(ctx, payloadSampledKey{}, sampled)
+}
+
+// logPayload logs the payload in full only if it is sampled, otherwise only its hash, length and language pair
+func logPayload(ctx context.Context, direction, methodName string, payload []byte) {
+	if !yandexLog.Enabled(ctx, slog.LevelDebug) {
+		return
+	}
+	args := []any{"direction", direction, "method", methodName}
+	if sampled, _ := ctx.Value(payloadSampledKey{}).(bool); sampled {
+		yandexLog.DebugCtx(ctx, "payload", append(args, "body", string(payload))...)
+	} else {
+		yandexLog.DebugCtx(ctx, "payload", append(args, payloadSummary(payload)...)...)
+	}
+}
+
+// payloadSummary describes a payload without its text
+func payloadSummary(payload []byte) []any {
+	sum := sha256.Sum256(payload)
+	summary := []any{"sha256", hex.EncodeToString(sum[:8]), "bytes", len(payload)}
+	translate := new(struct {
+		SourceLanguageCode string        `json:"sourceLanguageCode"`
+		TargetLanguageCode string        `json:"targetLanguageCode"`
+		Texts              []string      `json:"texts"`
+		Text               string        `json:"text"`
+		Translations       []Translation `json:"translations"`
+	})
+	if err := json.Unmarshal(payload, translate); err != nil {
+		return summary
+	}
+	if len(translate.TargetLanguageCode) > 0 {
+		summary = append(summary, "language_pair", languagePair(translate.SourceLanguageCode, translate.TargetLanguageCode))
+	}
+	texts := translate.Texts
+	if len(translate.Text) > 0 {
+		texts = append(texts, translate.Text)
+	}
+	for _, translation := range translate.Translations {
+		texts = append(texts, translation.Text)
+	}
+	if len(texts) > 0 {
+		summary = append(summary, "texts", len(texts), "characters", countCharacters(texts))
+	}
+	return summary
+}
+
+// maskSecret keeps only the edges of a token to recognize it in logs
+func maskSecret(secret string) string {
+	if len(secret) <= 12 {
+		return strings.Repeat("*", len(secret))
+	}
+	return secret[:4] + "..." + secret[len(secret)-4:]
 }
 
-func logPayload(typ string, payload []byte) {
-	log.Println(typ, ":", string(payload))
+// requestIDHeader returns the request ID to the client for support requests
+func requestIDHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		response.Header().Set(middleware.RequestIDHeader, middleware.GetReqID(request.Context()))
+		next.ServeHTTP(response, request)
+	})
 }
 
-func logDebugf(format string, v ...any) {
-	log.Printf(format+"\n", v...)
+// accessLog logs every inbound request with the matched route and the response status
+func accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(response, request.ProtoMajor)
+		next.ServeHTTP(ww, request)
+		serverLog.InfoCtx(request.Context(), "request",
+			"http_method", request.Method,
+			"path", request.URL.Path,
+			"route", chi.RouteContext(request.Context()).RoutePattern(),
+			"status", ww.Status(),
+			"bytes", ww.BytesWritten(),
+			"duration", time.Since(start),
+			"remote", request.RemoteAddr,
+		)
+	})
 }
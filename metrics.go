@@ -0,0 +1,61 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+var latencyBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30}
+
+var (
+	inboundRequests = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "translate_proxy_requests_total",
+		Help: "Inbound requests by route, HTTP method and status."}, []string{"route", "method", "status"})
+	inboundDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "translate_proxy_request_duration_seconds",
+		Help: "Inbound request handling duration by route and HTTP method.", Buckets: latencyBuckets}, []string{"route", "method"})
+	upstreamDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{Name: "translate_proxy_upstream_request_duration_seconds",
+		Help: "Yandex Cloud API call attempt duration by method name and status, the status is 'error' if no response received.", Buckets: latencyBuckets}, []string{"method", "status"})
+	translatedCharactersTotal = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "translate_proxy_translated_characters_total",
+		Help: "Characters successfully translated by upstream by language pair."}, []string{"language_pair"})
+	iamTokenRefreshes = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "translate_proxy_iam_token_refreshes_total",
+		Help: "IAM token refreshes by result."}, []string{"result"})
+	cacheLookups = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "translate_proxy_cache_lookups_total",
+		Help: "Translations cache lookups by result: memory_hit, disk_hit or miss."}, []string{"result"})
+	upstreamAccountEjections = prometheus.NewCounterVec(prometheus.CounterOpts{Name: "translate_proxy_upstream_account_ejections_total",
+		Help: "Upstream pool accounts taken out of rotation by 401, 403 or 429 statuses."}, []string{"account"})
+)
+
+func init() {
+	prometheus.MustRegister(inboundRequests, inboundDuration, upstreamDuration, translatedCharactersTotal, iamTokenRefreshes, cacheLookups, upstreamAccountEjections)
+}
+
+// newMetricsServer exposes the default registry metrics, including the Go runtime and process ones
+func newMetricsServer(addr string) *http.Server {
+	r := chi.NewRouter()
+	r.Method(http.MethodGet, "/metrics", promhttp.Handler())
+	return &http.Server{Addr: addr, Handler: r}
+}
+
+// measureRequests counts inbound requests by the matched route pattern
+func measureRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		start := time.Now()
+		ww := middleware.NewWrapResponseWriter(response, request.ProtoMajor)
+		next.ServeHTTP(ww, request)
+		route := chi.RouteContext(request.Context()).RoutePattern()
+		if len(route) == 0 {
+			route = "unmatched"
+		}
+		status := ww.Status()
+		if status == 0 {
+			status = http.StatusOK
+		}
+		inboundRequests.WithLabelValues(route, request.Method, strconv.Itoa(status)).Inc()
+		inboundDuration.WithLabelValues(route, request.Method).Observe(time.Since(start).Seconds())
+	})
+}
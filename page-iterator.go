@@ -0,0 +1,57 @@
+package main
+
+// PageIterator iterates over elements of a paged listing, the next page is requested when the current one is over.
+//
+//	for it := yandex.Clouds(""); it.Next(); {
+//		cloud := it.Value()
+//	}
+type PageIterator[T any] struct {
+	fetch     func(pageToken string) ([]T, string, error)
+	page      []T
+	index     int
+	pageToken string
+	last      bool
+	err       error
+}
+
+func NewPageIterator[T any](fetch func(pageToken string) ([]T, string, error)) *PageIterator[T] {
+	return &PageIterator[T]{fetch: fetch, index: -1}
+}
+
+// Next moves to the next element, returns false when the listing is over or on an error
+func (it *PageIterator[T]) Next() bool {
+	if it.err != nil {
+		return false
+	}
+	it.index++
+	for it.index >= len(it.page) {
+		if it.last {
+			return false
+		}
+		page, nextPageToken, err := it.fetch(it.pageToken)
+		if err != nil {
+			it.err = err
+			return false
+		}
+		it.page, it.index, it.pageToken = page, 0, nextPageToken
+		it.last = len(nextPageToken) == 0
+	}
+	return true
+}
+
+func (it *PageIterator[T]) Value() T {
+	return it.page[it.index]
+}
+
+func (it *PageIterator[T]) Err() error {
+	return it.err
+}
+
+// Collect reads all remaining elements
+func (it *PageIterator[T]) Collect() ([]T, error) {
+	var elements []T
+	for it.Next() {
+		elements = append(elements, it.Value())
+	}
+	return elements, it.Err()
+}
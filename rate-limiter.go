@@ -0,0 +1,208 @@
+package main
+
+import (
+	"context"
+	"math"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	rateLimiterSweepInterval = time.Minute
+)
+
+// RateLimit restricts requests and characters of translate requests, zero means no limit
+type RateLimit struct {
+	RequestsPerSecond   float64 `yaml:",omitempty"`
+	CharactersPerMinute int64   `yaml:",omitempty"`
+}
+
+// RateLimits are applied to every client identity and to every language pair of a client separately
+type RateLimits struct {
+	Client       RateLimit `yaml:",omitempty"`
+	LanguagePair RateLimit `yaml:",omitempty"`
+}
+
+// RateLimitError rejects a client's request exceeding a limit
+type RateLimitError struct {
+	RetryAfter time.Duration
+	message    string
+}
+
+func (e *RateLimitError) Error() string {
+	return e.message
+}
+
+var _ error = (*RateLimitError)(nil)
+
+func NewRateLimiter(limits RateLimits) *RateLimiter {
+	return &RateLimiter{limits: limits, buckets: map[bucketKey]*tokenBucket{}, lastSweep: time.Now()}
+}
+
+// RateLimiter keeps token buckets of clients and their language pairs
+type RateLimiter struct {
+	limits    RateLimits
+	mu        sync.Mutex
+	buckets   map[bucketKey]*tokenBucket
+	lastSweep time.Time
+}
+
+type bucketKey struct {
+	client       string
+	languagePair string
+	characters   bool
+}
+
+// bucketDemand is an amount of tokens required from a bucket
+type bucketDemand struct {
+	key         bucketKey
+	perSecond   float64
+	capacity    float64
+	amount      float64
+	description string
+}
+
+type tokenBucket struct {
+	tokens    float64
+	capacity  float64
+	perSecond float64
+	updated   time.Time
+}
+
+func (b *tokenBucket) refill(now time.Time) {
+	b.tokens = math.Min(b.capacity, b.tokens+now.Sub(b.updated).Seconds()*b.perSecond)
+	b.updated = now
+}
+
+// wait returns how long to wait for the amount, an amount bigger than the capacity waits for the full bucket
+func (b *tokenBucket) wait(amount float64) time.Duration {
+	need := math.Min(amount, b.capacity)
+	if b.tokens >= need {
+		return 0
+	}
+	return time.Duration((need - b.tokens) / b.perSecond * float64(time.Second))
+}
+
+// AllowRequest takes a request token of the client
+func (l *RateLimiter) AllowRequest(client string) error {
+	var demands []bucketDemand
+	if limit := l.limits.Client; limit.RequestsPerSecond > 0 {
+		demands = append(demands, requestsDemand(bucketKey{client: client}, limit, "requests per second of the client"))
+	}
+	return l.take(demands)
+}
+
+// AllowTranslate takes a request token of the language pair and the characters of the client and the language pair
+func (l *RateLimiter) AllowTranslate(client, languagePair string, characters int) error {
+	var demands []bucketDemand
+	pairKey := bucketKey{client: client, languagePair: languagePair}
+	if limit := l.limits.LanguagePair; limit.RequestsPerSecond > 0 {
+		demands = append(demands, requestsDemand(pairKey, limit, "requests per second of the language pair "+languagePair))
+	}
+	if limit := l.limits.LanguagePair; limit.CharactersPerMinute > 0 {
+		demands = append(demands, charactersDemand(pairKey, limit, characters, "characters per minute of the language pair "+languagePair))
+	}
+	if limit := l.limits.Client; limit.CharactersPerMinute > 0 {
+		demands = append(demands, charactersDemand(bucketKey{client: client}, limit, characters, "characters per minute of the client"))
+	}
+	return l.take(demands)
+}
+
+func requestsDemand(key bucketKey, limit RateLimit, description string) bucketDemand {
+	return bucketDemand{key: key, perSecond: limit.RequestsPerSecond, capacity: math.Max(1, limit.RequestsPerSecond), amount: 1, description: description}
+}
+
+func charactersDemand(key bucketKey, limit RateLimit, characters int, description string) bucketDemand {
+	key.characters = true
+	perMinute := float64(limit.CharactersPerMinute)
+	return bucketDemand{key: key, perSecond: perMinute / 60, capacity: perMinute, amount: float64(characters), description: description}
+}
+
+// take consumes all the demands or nothing if any bucket has not enough tokens
+func (l *RateLimiter) take(demands []bucketDemand) error {
+	if len(demands) == 0 {
+		return nil
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	now := time.Now()
+	l.sweep(now)
+	buckets := make([]*tokenBucket, len(demands))
+	for i, demand := range demands {
+		bucket, ok := l.buckets[demand.key]
+		if !ok {
+			bucket = &tokenBucket{tokens: demand.capacity, capacity: demand.capacity, perSecond: demand.perSecond, updated: now}
+			l.buckets[demand.key] = bucket
+		}
+		bucket.refill(now)
+		if wait := bucket.wait(demand.amount); wait > 0 {
+			return &RateLimitError{RetryAfter: wait, message: "rate limit exceeded: " + demand.description}
+		}
+		buckets[i] = bucket
+	}
+	for i, bucket := range buckets {
+		bucket.tokens -= demands[i].amount
+	}
+	return nil
+}
+
+// sweep removes refilled buckets of idle clients, must be called under the lock
+func (l *RateLimiter) sweep(now time.Time) {
+	if now.Sub(l.lastSweep) < rateLimiterSweepInterval {
+		return
+	}
+	l.lastSweep = now
+	for key, bucket := range l.buckets {
+		if bucket.refill(now); bucket.tokens >= bucket.capacity {
+			delete(l.buckets, key)
+		}
+	}
+}
+
+type clientIdentityKey struct{}
+
+// rateLimit identifies the client and rejects requests exceeding the client limit by the API specific error writer
+func (h *Handler) rateLimit(writeErr func(response http.ResponseWriter, request *http.Request, err error)) func(next http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+			limiter := h.access.Load().limiter
+			if limiter == nil || request.Method == http.MethodOptions {
+				next.ServeHTTP(response, request)
+				return
+			}
+			client := requestClient(request)
+			if err := limiter.AllowRequest(client); err != nil {
+				writeErr(response, request, err)
+				return
+			}
+			next.ServeHTTP(response, request.WithContext(context.WithValue(request.Context(), clientIdentityKey{}, client)))
+		})
+	}
+}
+
+// allowTranslate applies the language pair and characters limits of the client identified by the rateLimit middleware.
+// All the request characters are charged, including the ones served from the cache later,
+// so a client's limits don't depend on the cache state and bound the upstream characters from above.
+func (h *Handler) allowTranslate(ctx context.Context, payload *TranslateRequest) error {
+	limiter := h.access.Load().limiter
+	if limiter == nil {
+		return nil
+	}
+	client, _ := ctx.Value(clientIdentityKey{}).(string)
+	return limiter.AllowTranslate(client, languagePair(payload.SourceLanguageCode, payload.TargetLanguageCode), countCharacters(payload.Texts))
+}
+
+// requestClient identifies the client by the API key name validated by the authenticate middleware or by the remote IP.
+// Unchecked keys are ignored, otherwise a client would get a new bucket by every made-up key.
+func requestClient(request *http.Request) string {
+	if apiKey, ok := request.Context().Value(apiKeyContextKey{}).(*APIKey); ok {
+		return "name:" + apiKey.Name
+	}
+	ip, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		ip = request.RemoteAddr
+	}
+	return "ip:" + ip
+}
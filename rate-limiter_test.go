@@ -0,0 +1,145 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func charactersDemandOf(client string, perMinute int64, characters int) bucketDemand {
+	return charactersDemand(bucketKey{client: client}, RateLimit{CharactersPerMinute: perMinute}, characters, client)
+}
+
+func assertTokens(t *testing.T, l *RateLimiter, demand bucketDemand, expected float64) {
+	t.Helper()
+	bucket, ok := l.buckets[demand.key]
+	if !ok {
+		t.Fatalf("no bucket %v", demand.key)
+	}
+	//refill between the take and the check is negligible
+	if math.Abs(bucket.tokens-expected) > 0.1 {
+		t.Errorf("bucket %v tokens %f, expected %f", demand.key, bucket.tokens, expected)
+	}
+}
+
+func assertRateLimitError(t *testing.T, err error, description string, retryAfter time.Duration) {
+	t.Helper()
+	var rateLimitErr *RateLimitError
+	if !errors.As(err, &rateLimitErr) {
+		t.Fatalf("error %v, expected a rate limit error", err)
+	}
+	if !strings.HasSuffix(rateLimitErr.Error(), description) {
+		t.Errorf("error %q, expected the %q limit", rateLimitErr.Error(), description)
+	}
+	if rateLimitErr.RetryAfter > retryAfter || rateLimitErr.RetryAfter < retryAfter-100*time.Millisecond {
+		t.Errorf("retry after %s, expected %s", rateLimitErr.RetryAfter, retryAfter)
+	}
+}
+
+func TestRateLimiterTakeAllOrNothing(t *testing.T) {
+	l := NewRateLimiter(RateLimits{})
+	large, small := charactersDemandOf("large", 600, 4), charactersDemandOf("small", 300, 4)
+	if err := l.take([]bucketDemand{large, small}); err != nil {
+		t.Fatal(err)
+	}
+	assertTokens(t, l, large, 596)
+	assertTokens(t, l, small, 296)
+
+	large.amount, small.amount = 100, 297
+	//small has 296 tokens refilled by 5 per second
+	assertRateLimitError(t, l.take([]bucketDemand{large, small}), "small", 200*time.Millisecond)
+	assertTokens(t, l, large, 596)
+	assertTokens(t, l, small, 296)
+}
+
+func TestRateLimiterRetryAfter(t *testing.T) {
+	l := NewRateLimiter(RateLimits{})
+	demand := requestsDemand(bucketKey{client: "c"}, RateLimit{RequestsPerSecond: 2}, "requests")
+	for i := 0; i < 2; i++ {
+		if err := l.take([]bucketDemand{demand}); err != nil {
+			t.Fatalf("request %d: %v", i, err)
+		}
+	}
+	assertRateLimitError(t, l.take([]bucketDemand{demand}), "requests", 500*time.Millisecond)
+
+	//a rate below one request per second still allows a burst of one request
+	slow := requestsDemand(bucketKey{client: "slow"}, RateLimit{RequestsPerSecond: 0.5}, "slow requests")
+	if err := l.take([]bucketDemand{slow}); err != nil {
+		t.Fatal(err)
+	}
+	assertRateLimitError(t, l.take([]bucketDemand{slow}), "slow requests", 2*time.Second)
+}
+
+func TestRateLimiterOverCapacity(t *testing.T) {
+	l := NewRateLimiter(RateLimits{})
+	//10 characters per minute, the bucket is refilled by a character every 6 seconds
+	demand := charactersDemandOf("c", 10, 25)
+	if err := l.take([]bucketDemand{demand}); err != nil {
+		t.Fatalf("a request over the capacity is rejected by the full bucket: %v", err)
+	}
+	assertTokens(t, l, demand, -15)
+
+	demand.amount = 1
+	assertRateLimitError(t, l.take([]bucketDemand{demand}), "c", 96*time.Second)
+	demand.amount = 25
+	//waits for the full bucket only
+	assertRateLimitError(t, l.take([]bucketDemand{demand}), "c", 150*time.Second)
+}
+
+func TestRateLimiterNoDemands(t *testing.T) {
+	l := NewRateLimiter(RateLimits{})
+	if err := l.take(nil); err != nil {
+		t.Fatal(err)
+	} else if err := l.AllowRequest("c"); err != nil {
+		t.Fatal(err)
+	} else if err := l.AllowTranslate("c", "en-ru", 1000); err != nil {
+		t.Fatal(err)
+	}
+	if len(l.buckets) != 0 {
+		t.Errorf("buckets %d, expected none", len(l.buckets))
+	}
+}
+
+func TestRateLimiterSweep(t *testing.T) {
+	l := NewRateLimiter(RateLimits{})
+	idle, busy := charactersDemandOf("idle", 60, 30), charactersDemandOf("busy", 6000, 6000)
+	if err := l.take([]bucketDemand{idle, busy}); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	//idle is refilled by a character per second, busy needs a minute
+	l.sweep(now.Add(time.Minute - time.Second))
+	if len(l.buckets) != 2 {
+		t.Fatalf("buckets %d are swept before the interval", len(l.buckets))
+	}
+	l.lastSweep = now.Add(-rateLimiterSweepInterval)
+	l.sweep(now.Add(30 * time.Second))
+	if _, ok := l.buckets[idle.key]; ok {
+		t.Error("refilled bucket is not swept")
+	}
+	if _, ok := l.buckets[busy.key]; !ok {
+		t.Error("not refilled bucket is swept")
+	}
+}
+
+func TestRateLimiterAllowTranslate(t *testing.T) {
+	l := NewRateLimiter(RateLimits{
+		Client:       RateLimit{CharactersPerMinute: 20},
+		LanguagePair: RateLimit{CharactersPerMinute: 10},
+	})
+	if err := l.AllowTranslate("c", "en-ru", 8); err != nil {
+		t.Fatal(err)
+	} else if err := l.AllowTranslate("c", "en-de", 8); err != nil {
+		t.Fatalf("language pairs share a bucket: %v", err)
+	} else if err := l.AllowTranslate("other", "en-ru", 8); err != nil {
+		t.Fatalf("clients share a bucket: %v", err)
+	}
+	if err := l.AllowTranslate("c", "en-ru", 8); err == nil || !strings.HasSuffix(err.Error(), "language pair en-ru") {
+		t.Errorf("error %v, expected the language pair limit", err)
+	}
+	if err := l.AllowTranslate("c", "en-fr", 8); err == nil || !strings.HasSuffix(err.Error(), "of the client") {
+		t.Errorf("error %v, expected the client limit", err)
+	}
+}
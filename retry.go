@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"syscall"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+// RetryPolicy repeats failed upstream calls with exponential backoff and jitter.
+// The zero policy makes a single attempt, so it fits calls that are not safe to repeat.
+type RetryPolicy struct {
+	MaxAttempts    int
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+	//throttled calls are not repeated, an upstream pool fails them over to another account instead
+	ThrottledFailover bool
+	//bounds a call with all its attempts, so retries stop before it, 0 means no limit
+	Timeout time.Duration
+}
+
+var noRetry = RetryPolicy{}
+
+// do calls until success, a not transient error, attempts exhaustion or the ctx deadline
+func (p RetryPolicy) do(ctx context.Context, methodName string, call func(attempt int) error) error {
+	for attempt := 1; ; attempt++ {
+		err := call(attempt)
+		if err == nil || attempt >= p.MaxAttempts || !p.retryable(err) {
+			return err
+		}
+		delay, ok := p.backoff(attempt, err)
+		if !ok {
+			//the caller gets the upstream Retry-After instead of waiting for it here
+			yandexLog.DebugCtx(ctx, "upstream asks to retry later than the max backoff", "method", methodName, "attempt", attempt, slog.ErrorKey, err)
+			return err
+		} else if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			yandexLog.DebugCtx(ctx, "no time to retry before the deadline", "method", methodName, "attempt", attempt, slog.ErrorKey, err)
+			return err
+		}
+		yandexLog.DebugCtx(ctx, "retry", "method", methodName, "attempt", attempt, "delay", delay, slog.ErrorKey, err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return err
+		case <-timer.C:
+		}
+	}
+}
+
+func (p RetryPolicy) retryable(err error) bool {
+	var statusErr *HTTPStatusError
+	if p.ThrottledFailover && errors.As(err, &statusErr) && statusErr.Code == http.StatusTooManyRequests {
+		return false
+	}
+	return isTransient(err)
+}
+
+// backoff doubles the delay every attempt with a random jitter, the upstream Retry-After header takes precedence.
+// Returns false if the Retry-After exceeds the max backoff.
+func (p RetryPolicy) backoff(attempt int, err error) (time.Duration, bool) {
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) {
+		if retryAfter, ok := parseRetryAfter(statusErr.RetryAfter); ok {
+			return retryAfter, retryAfter <= p.MaxBackoff
+		}
+	}
+	delay := p.InitialBackoff << (attempt - 1)
+	if delay <= 0 || (p.MaxBackoff > 0 && delay > p.MaxBackoff) {
+		delay = p.MaxBackoff
+	}
+	half := delay / 2
+	if half <= 0 {
+		return delay, true
+	}
+	return half + time.Duration(rand.Int63n(int64(half))), true
+}
+
+// isTransient recognizes throttling, upstream unavailability and broken connections
+func isTransient(err error) bool {
+	var (
+		statusErr *HTTPStatusError
+		opErr     *net.OpError
+		netErr    net.Error
+	)
+	switch {
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return false
+	case errors.As(err, &statusErr):
+		switch statusErr.Code {
+		case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return true
+		default:
+			return false
+		}
+	case errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, io.EOF):
+		return true
+	case errors.As(err, &opErr):
+		return true
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return true
+	default:
+		return false
+	}
+}
+
+// parseRetryAfter supports both seconds and HTTP date formats
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if len(value) == 0 {
+		return 0, false
+	} else if seconds, err := strconv.Atoi(value); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	} else if date, err := http.ParseTime(value); err == nil {
+		if delay := time.Until(date); delay > 0 {
+			return delay, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
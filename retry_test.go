@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestDoRequestRetriesWithinTimeout(t *testing.T) {
+	var calls atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(response http.ResponseWriter, request *http.Request) {
+		calls.Add(1)
+		response.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	retry := RetryPolicy{MaxAttempts: 10, InitialBackoff: 100 * time.Millisecond, MaxBackoff: 100 * time.Millisecond}
+	request := func() *http.Request {
+		req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return req
+	}
+	if err := doRequest("test", server.Client(), retry, request(), new(struct{}), false); err == nil {
+		t.Fatal("unavailable upstream succeeds")
+	} else if calls.Load() != 10 {
+		t.Errorf("calls %d, expected all 10 attempts without a timeout", calls.Load())
+	}
+
+	calls.Store(0)
+	retry.Timeout = 120 * time.Millisecond
+	start := time.Now()
+	err := doRequest("test", server.Client(), retry, request(), new(struct{}), false)
+	var statusErr *HTTPStatusError
+	if !errors.As(err, &statusErr) || statusErr.Code != http.StatusServiceUnavailable {
+		t.Errorf("error %v, expected the upstream status", err)
+	}
+	//the jitter makes a delay 50-100ms, so only a few attempts fit the timeout
+	if calls.Load() < 2 || calls.Load() > 3 {
+		t.Errorf("calls %d, expected 2 or 3 within the timeout", calls.Load())
+	} else if elapsed := time.Since(start); elapsed > retry.Timeout {
+		t.Errorf("call takes %s, longer than the timeout %s", elapsed, retry.Timeout)
+	}
+}
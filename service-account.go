@@ -0,0 +1,103 @@
+package main
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+)
+
+const (
+	serviceAccountJWTLifetime = time.Hour
+)
+
+// ServiceAccountKey is a service account authorized key created by 'yc iam key create'
+type ServiceAccountKey struct {
+	ID               string `json:"id"`
+	ServiceAccountID string `json:"service_account_id"`
+	PrivateKey       string `json:"private_key"`
+}
+
+func ReadServiceAccountKey(file string) (*ServiceAccountKey, error) {
+	payload, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	key := new(ServiceAccountKey)
+	if err := json.Unmarshal(payload, key); err != nil {
+		return nil, fmt.Errorf("service account key unmarshal: %w", err)
+	} else if len(key.ID) == 0 || len(key.ServiceAccountID) == 0 || len(key.PrivateKey) == 0 {
+		return nil, errors.New("service account key must contain id, service_account_id and private_key")
+	}
+	return key, nil
+}
+
+// SignedJWT makes a PS256 signed JWT to exchange to an IAM token at the audience URL
+func (k *ServiceAccountKey) SignedJWT(audience string, now time.Time) (string, error) {
+	privateKey, err := k.parsePrivateKey()
+	if err != nil {
+		return "", err
+	}
+	header, err := json.Marshal(&jwtHeader{Type: "JWT", Algorithm: "PS256", KeyID: k.ID})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(&jwtClaims{
+		Issuer:    k.ServiceAccountID,
+		Audience:  audience,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(serviceAccountJWTLifetime).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	encoding := base64.RawURLEncoding
+	unsigned := encoding.EncodeToString(header) + "." + encoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPSS(rand.Reader, privateKey, crypto.SHA256, digest[:], &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	if err != nil {
+		return "", fmt.Errorf("jwt sign: %w", err)
+	}
+	return unsigned + "." + encoding.EncodeToString(signature), nil
+}
+
+func (k *ServiceAccountKey) parsePrivateKey() (*rsa.PrivateKey, error) {
+	//the key may be prefixed by a comment line that pem.Decode skips
+	block, _ := pem.Decode([]byte(k.PrivateKey))
+	if block == nil {
+		return nil, errors.New("service account private key is not PEM encoded")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		if rsaKey, rsaErr := x509.ParsePKCS1PrivateKey(block.Bytes); rsaErr == nil {
+			return rsaKey, nil
+		}
+		return nil, fmt.Errorf("service account private key parse: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("service account private key must be RSA, actual %T", key)
+	}
+	return rsaKey, nil
+}
+
+type jwtHeader struct {
+	Type      string `json:"typ"`
+	Algorithm string `json:"alg"`
+	KeyID     string `json:"kid"`
+}
+
+type jwtClaims struct {
+	Issuer    string `json:"iss"`
+	Audience  string `json:"aud"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
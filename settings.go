@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"reflect"
+	"strings"
+	"time"
+
+	"golang.org/x/exp/slog"
+	"gopkg.in/yaml.v2"
+)
+
+// Settings is the typed schema of the config file settings section, the keys are the flag names.
+// The precedence is flags > environment variables > config file > defaults.
+// The credentials and the folder are the top-level config fields.
+type Settings struct {
+	ConfigReloadInterval   *time.Duration `yaml:"config-reload-interval,omitempty"`
+	NewFolderName          *string        `yaml:"new-folder-name,omitempty"`
+	CloudID                *string        `yaml:"cloud-id,omitempty"`
+	NonInteractive         *bool          `yaml:"non-interactive,omitempty"`
+	AllFolders             *bool          `yaml:"all-folders,omitempty"`
+	OAuthTokenURL          *string        `yaml:"oauth-token-url,omitempty"`
+	IamTokenURL            *string        `yaml:"iam-token-url,omitempty"`
+	IamTokenRefreshBefore  *time.Duration `yaml:"iam-token-refresh-before,omitempty"`
+	CloudsURL              *string        `yaml:"clouds-url,omitempty"`
+	CloudFoldersURL        *string        `yaml:"cloud-folders-url,omitempty"`
+	TranslateURL           *string        `yaml:"translate-url,omitempty"`
+	DetectURL              *string        `yaml:"detect-url,omitempty"`
+	LanguagesURL           *string        `yaml:"languages-url,omitempty"`
+	TranslateMaxCharacters *int           `yaml:"translate-max-characters,omitempty"`
+	TranslateMaxTexts      *int           `yaml:"translate-max-texts,omitempty"`
+	TranslateParallelism   *int           `yaml:"translate-parallelism,omitempty"`
+	RetryMaxAttempts       *int           `yaml:"retry-max-attempts,omitempty"`
+	RetryInitialBackoff    *time.Duration `yaml:"retry-initial-backoff,omitempty"`
+	RetryMaxBackoff        *time.Duration `yaml:"retry-max-backoff,omitempty"`
+	UpstreamTimeout        *time.Duration `yaml:"upstream-timeout,omitempty"`
+	Address                *string        `yaml:"address,omitempty"`
+	Insecure               *bool          `yaml:"insecure,omitempty"`
+	Accesslog              *bool          `yaml:"accesslog,omitempty"`
+	LogLevel               *string        `yaml:"log-level,omitempty"`
+	LogFormat              *string        `yaml:"log-format,omitempty"`
+	LogPrivacy             *bool          `yaml:"log-privacy,omitempty"`
+	LogPayloadSampleRate   *float64       `yaml:"log-payload-sample-rate,omitempty"`
+	MetricsAddress         *string        `yaml:"metrics-address,omitempty"`
+	TLSCertFile            *string        `yaml:"tls-cert-file,omitempty"`
+	TLSKeyFile             *string        `yaml:"tls-key-file,omitempty"`
+	ShutdownTimeout        *time.Duration `yaml:"shutdown-timeout,omitempty"`
+	ShutdownDelay          *time.Duration `yaml:"shutdown-delay,omitempty"`
+	CacheSize              *int           `yaml:"cache-size,omitempty"`
+	CacheTTL               *time.Duration `yaml:"cache-ttl,omitempty"`
+	CacheDir               *string        `yaml:"cache-dir,omitempty"`
+	CacheDiskSize          *int           `yaml:"cache-disk-size,omitempty"`
+	BatchWindow            *time.Duration `yaml:"batch-window,omitempty"`
+	PoolStrategy           *string        `yaml:"pool-strategy,omitempty"`
+	PoolEjection           *time.Duration `yaml:"pool-ejection,omitempty"`
+	BatchMaxTexts          *int           `yaml:"batch-max-texts,omitempty"`
+	BatchTimeout           *time.Duration `yaml:"batch-timeout,omitempty"`
+	UsageCharacterLimit    *int64         `yaml:"usage-character-limit,omitempty"`
+}
+
+// settingName returns the flag name of the Settings field
+func settingName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+	return name
+}
+
+// applySettings sets the flags passed neither by the command line nor by environment variables
+func applySettings(settings *Settings) error {
+	if settings == nil {
+		return nil
+	}
+	passed := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { passed[f.Name] = true })
+	value := reflect.ValueOf(settings).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := settingName(value.Type().Field(i))
+		field := value.Field(i)
+		if field.IsNil() || passed[name] {
+			continue
+		} else if _, ok := os.LookupEnv(envName(name)); ok {
+			continue
+		} else if err := flag.Set(name, fmt.Sprint(field.Elem().Interface())); err != nil {
+			return fmt.Errorf("setting %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// effectiveSettings collects the merged values of all settings
+func effectiveSettings() *Settings {
+	settings := new(Settings)
+	value := reflect.ValueOf(settings).Elem()
+	for i := 0; i < value.NumField(); i++ {
+		name := settingName(value.Type().Field(i))
+		field := value.Field(i)
+		current := reflect.New(field.Type().Elem())
+		current.Elem().Set(reflect.ValueOf(flag.Lookup(name).Value.(flag.Getter).Get()))
+		field.Set(current)
+	}
+	return settings
+}
+
+// validateConfig checks the merged configuration and reports all mistakes at once
+func validateConfig(config *Config) error {
+	var errs []error
+	check := func(ok bool, setting, format string, args ...any) {
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s: %s", setting, fmt.Sprintf(format, args...)))
+		}
+	}
+	checkAddress := func(setting, address string) {
+		_, _, err := net.SplitHostPort(address)
+		check(err == nil, setting, "must be host:port, actual %q", address)
+	}
+	checkURL := func(setting, value string) {
+		u, err := url.Parse(value)
+		check(err == nil && (u.Scheme == "http" || u.Scheme == "https") && len(u.Host) > 0, setting, "must be an absolute http(s) URL, actual %q", value)
+	}
+	checkNotNegative := func(setting string, value int64) {
+		check(value >= 0, setting, "must not be negative, actual %d", value)
+	}
+
+	checkAddress("address", *address)
+	if len(*metricsAddress) > 0 {
+		checkAddress("metrics-address", *metricsAddress)
+		check(*metricsAddress != *address, "metrics-address", "must differ from the address")
+	}
+	check(len(*tlsCertFile) > 0 == (len(*tlsKeyFile) > 0), "tls-cert-file", "must be set together with tls-key-file")
+	for setting, file := range map[string]string{"tls-cert-file": *tlsCertFile, "tls-key-file": *tlsKeyFile, "service-account-key-file": config.ServiceAccountKeyFile} {
+		if len(file) > 0 {
+			_, err := os.Stat(file)
+			check(err == nil, setting, "%v", err)
+		}
+	}
+	for setting, value := range map[string]string{"oauth-token-url": *oAuthTokenURL, "iam-token-url": *iamTokenURL, "clouds-url": *cloudsURL,
+		"cloud-folders-url": *foldersURL, "translate-url": *translateURL, "detect-url": *detectURL, "languages-url": *languagesURL} {
+		checkURL(setting, value)
+	}
+	for setting, value := range map[string]int{"translate-max-characters": *translateMaxChars, "translate-max-texts": *translateMaxTexts,
+		"translate-parallelism": *translateParallelism, "retry-max-attempts": *retryMaxAttempts, "cache-size": *cacheSize,
+		"cache-disk-size": *cacheDiskSize, "batch-max-texts": *batchMaxTexts} {
+		checkNotNegative(setting, int64(value))
+	}
+	checkNotNegative("usage-character-limit", *charLimit)
+	check(len(*cacheDir) == 0 || *cacheDiskSize > 0, "cache-disk-size", "must be positive when cache-dir is set, actual %d", *cacheDiskSize)
+	for setting, value := range map[string]time.Duration{"config-reload-interval": *configReloadInterval, "shutdown-timeout": *shutdownTimeout, "shutdown-delay": *shutdownDelay, "pool-ejection": *poolEjection, "iam-token-refresh-before": *iamTokenRefreshBefore, "retry-initial-backoff": *retryInitialBackoff,
+		"retry-max-backoff": *retryMaxBackoff, "cache-ttl": *cacheTTL, "batch-window": *batchWindow, "batch-timeout": *batchTimeout, "upstream-timeout": *upstreamTimeout} {
+		check(value >= 0, setting, "must not be negative, actual %s", value)
+	}
+	check(*retryMaxBackoff >= *retryInitialBackoff, "retry-max-backoff", "must not be less than retry-initial-backoff %s", *retryInitialBackoff)
+	var level slog.Level
+	check(level.UnmarshalText([]byte(*logLevelName)) == nil, "log-level", "must be debug, info, warn or error, actual %q", *logLevelName)
+	check(*logFormat == logFormatText || *logFormat == logFormatJSON, "log-format", "must be %s or %s, actual %q", logFormatText, logFormatJSON, *logFormat)
+	check(*logPayloadSampleRate >= 0 && *logPayloadSampleRate <= 1, "log-payload-sample-rate", "must be in the range 0 to 1, actual %v", *logPayloadSampleRate)
+	for setting, limit := range map[string]RateLimit{"ratelimits.client": config.RateLimits.Client, "ratelimits.languagepair": config.RateLimits.LanguagePair} {
+		check(limit.RequestsPerSecond >= 0 && limit.CharactersPerMinute >= 0, setting, "limits must not be negative")
+	}
+	check(config.Translator == "" || config.Translator == yandexTranslator, "translator", "unsupported translator %q", config.Translator)
+	if err := validateAPIKeys(config.APIKeys); err != nil {
+		errs = append(errs, fmt.Errorf("apikeys: %w", err))
+	}
+	if err := validatePoolStrategy(*poolStrategy); err != nil {
+		errs = append(errs, fmt.Errorf("pool-strategy: %w", err))
+	}
+	check(config.Weight >= 0 && config.Cost >= 0, "weight", "weight and cost must not be negative")
+	if err := validateAccounts(config.Accounts); err != nil {
+		errs = append(errs, fmt.Errorf("accounts: %w", err))
+	}
+	if err := validateGlossaries(config.Glossaries); err != nil {
+		errs = append(errs, fmt.Errorf("glossaries: %w", err))
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration:\n%w", errors.Join(errs...))
+	}
+	return nil
+}
+
+// printConfig writes the effective configuration with masked secrets
+func printConfig(config *Config) error {
+	printable := *config
+	printable.OAuthToken = maskSecret(printable.OAuthToken)
+	printable.IamToken = maskSecret(printable.IamToken)
+	printable.APIKeys = make([]APIKey, len(config.APIKeys))
+	for i, key := range config.APIKeys {
+		key.Key = maskSecret(key.Key)
+		printable.APIKeys[i] = key
+	}
+	printable.Accounts = make([]Account, len(config.Accounts))
+	for i, account := range config.Accounts {
+		account.OAuthToken = maskSecret(account.OAuthToken)
+		printable.Accounts[i] = account
+	}
+	printable.Settings = effectiveSettings()
+	payload, err := yaml.Marshal(&printable)
+	if err != nil {
+		return fmt.Errorf("config marshal: %w", err)
+	}
+	fmt.Printf("# config file %s\n%s", *configFile, payload)
+	return nil
+}
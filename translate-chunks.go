@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+)
+
+// TranslateLimits restricts a single upstream translate request, zero means no limit
+type TranslateLimits struct {
+	MaxCharacters int
+	MaxTexts      int
+	Parallelism   int
+}
+
+// textPiece is a part of a request text, the separator is the original whitespace after the part
+type textPiece struct {
+	index     int
+	text      string
+	separator string
+}
+
+// translateChunked splits the request by the limits, translates the chunks in parallel and joins the results in the original order
+func translateChunked(ctx context.Context, request *TranslateRequest, limits TranslateLimits, translate func(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error)) (*TranslateResponse, error) {
+	pieces := splitTexts(request.Texts, limits.MaxCharacters)
+	chunks := chunkPieces(pieces, limits)
+	if len(chunks) <= 1 && len(pieces) == len(request.Texts) {
+		return translate(ctx, request)
+	}
+	yandexLog.DebugCtx(ctx, "translate request split", "texts", len(request.Texts), "chunks", len(chunks))
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	parallelism := limits.Parallelism
+	if parallelism <= 0 {
+		parallelism = len(chunks)
+	}
+	semaphore := make(chan struct{}, parallelism)
+	results := make([]*TranslateResponse, len(chunks))
+	var (
+		wg       sync.WaitGroup
+		failOnce sync.Once
+		failErr  error
+	)
+	fail := func(err error) {
+		failOnce.Do(func() {
+			failErr = err
+			cancel()
+		})
+	}
+	for i, chunk := range chunks {
+		chunkRequest := *request
+		chunkRequest.Texts = make([]string, len(chunk))
+		for j, piece := range chunk {
+			chunkRequest.Texts[j] = piece.text
+		}
+		wg.Add(1)
+		go func(i int, chunkRequest *TranslateRequest) {
+			defer wg.Done()
+			select {
+			case semaphore <- struct{}{}:
+				defer func() { <-semaphore }()
+			case <-ctx.Done():
+				fail(ctx.Err())
+				return
+			}
+			resp, err := translate(ctx, chunkRequest)
+			if err != nil {
+				fail(err)
+			} else if len(resp.Translations) != len(chunkRequest.Texts) {
+				fail(fmt.Errorf("unexpected translations amount %d, expected %d", len(resp.Translations), len(chunkRequest.Texts)))
+			} else {
+				results[i] = resp
+			}
+		}(i, &chunkRequest)
+	}
+	wg.Wait()
+	if failErr != nil {
+		return nil, failErr
+	}
+
+	translations := make([]Translation, len(request.Texts))
+	started := make([]bool, len(request.Texts))
+	for i, chunk := range chunks {
+		for j, piece := range chunk {
+			translation := results[i].Translations[j]
+			if started[piece.index] {
+				translations[piece.index].Text += translation.Text + piece.separator
+			} else {
+				started[piece.index] = true
+				translations[piece.index] = Translation{Text: translation.Text + piece.separator, DetectedLanguageCode: translation.DetectedLanguageCode}
+			}
+		}
+	}
+	return &TranslateResponse{Translations: translations}, nil
+}
+
+// splitTexts splits texts longer than the maxCharacters at sentence boundaries
+func splitTexts(texts []string, maxCharacters int) []textPiece {
+	pieces := make([]textPiece, 0, len(texts))
+	for i, text := range texts {
+		if maxCharacters <= 0 || utf8.RuneCountInString(text) <= maxCharacters {
+			pieces = append(pieces, textPiece{index: i, text: text})
+			continue
+		}
+		for _, part := range splitText(text, maxCharacters) {
+			part.index = i
+			pieces = append(pieces, part)
+		}
+	}
+	return pieces
+}
+
+// chunkPieces groups the pieces in the original order to chunks restricted by the limits
+func chunkPieces(pieces []textPiece, limits TranslateLimits) [][]textPiece {
+	var (
+		chunks     [][]textPiece
+		chunk      []textPiece
+		characters int
+	)
+	for _, piece := range pieces {
+		n := utf8.RuneCountInString(piece.text)
+		overflow := (limits.MaxCharacters > 0 && characters+n > limits.MaxCharacters) || (limits.MaxTexts > 0 && len(chunk) >= limits.MaxTexts)
+		if len(chunk) > 0 && overflow {
+			chunks = append(chunks, chunk)
+			chunk, characters = nil, 0
+		}
+		chunk = append(chunk, piece)
+		characters += n
+	}
+	if len(chunk) > 0 {
+		chunks = append(chunks, chunk)
+	}
+	return chunks
+}
+
+// splitText splits the text at sentence boundaries, too long sentences are split at spaces, too long words are split hard
+func splitText(text string, maxCharacters int) []textPiece {
+	return packSegments(segments(text, isSentenceBoundary), maxCharacters, func(sentence textPiece) []textPiece {
+		return packSegments(segments(sentence.text, isWordBoundary), maxCharacters, func(word textPiece) []textPiece {
+			return splitHard(word.text, maxCharacters)
+		})
+	})
+}
+
+func isSentenceBoundary(last rune, space string) bool {
+	return strings.ContainsRune(space, '\n') || strings.ContainsRune(".!?…。！？;", last)
+}
+
+func isWordBoundary(rune, string) bool {
+	return true
+}
+
+// segments cuts the text at whitespaces which the boundary function accepts
+func segments(text string, boundary func(last rune, space string) bool) []textPiece {
+	var result []textPiece
+	start := 0
+	for i := 0; i < len(text); {
+		r, size := utf8.DecodeRuneInString(text[i:])
+		if !unicode.IsSpace(r) {
+			i += size
+			continue
+		}
+		end := i
+		for end < len(text) {
+			r, size := utf8.DecodeRuneInString(text[end:])
+			if !unicode.IsSpace(r) {
+				break
+			}
+			end += size
+		}
+		if i > start {
+			if last, _ := utf8.DecodeLastRuneInString(text[start:i]); boundary(last, text[i:end]) {
+				result = append(result, textPiece{text: text[start:i], separator: text[i:end]})
+				start = end
+			}
+		}
+		i = end
+	}
+	if start < len(text) {
+		result = append(result, textPiece{text: text[start:]})
+	}
+	return result
+}
+
+// packSegments joins neighbour segments while they fit the maxCharacters, the split function divides too long ones
+func packSegments(segments []textPiece, maxCharacters int, split func(segment textPiece) []textPiece) []textPiece {
+	var (
+		result     []textPiece
+		current    strings.Builder
+		characters int
+		separator  string
+	)
+	flush := func() {
+		if current.Len() > 0 {
+			result = append(result, textPiece{text: current.String(), separator: separator})
+			current.Reset()
+			characters, separator = 0, ""
+		}
+	}
+	for _, segment := range segments {
+		n := utf8.RuneCountInString(segment.text)
+		if n > maxCharacters {
+			flush()
+			parts := split(segment)
+			if len(parts) > 0 {
+				parts[len(parts)-1].separator += segment.separator
+			}
+			result = append(result, parts...)
+			continue
+		}
+		if current.Len() > 0 && characters+utf8.RuneCountInString(separator)+n > maxCharacters {
+			flush()
+		}
+		if current.Len() > 0 {
+			current.WriteString(separator)
+			characters += utf8.RuneCountInString(separator)
+		}
+		current.WriteString(segment.text)
+		characters += n
+		separator = segment.separator
+	}
+	flush()
+	return result
+}
+
+func splitHard(text string, maxCharacters int) []textPiece {
+	var result []textPiece
+	runes := []rune(text)
+	for start := 0; start < len(runes); start += maxCharacters {
+		end := start + maxCharacters
+		if end > len(runes) {
+			end = len(runes)
+		}
+		result = append(result, textPiece{text: string(runes[start:end])})
+	}
+	return result
+}
@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"unicode/utf8"
+)
+
+func TestSplitTexts(t *testing.T) {
+	tests := []struct {
+		name          string
+		text          string
+		maxCharacters int
+		expected      []string
+	}{
+		{name: "short text", text: "Hello world.", maxCharacters: 20, expected: []string{"Hello world."}},
+		{name: "no limit", text: "Hello world.", maxCharacters: 0, expected: []string{"Hello world."}},
+		{name: "empty text", text: "", maxCharacters: 5, expected: []string{""}},
+		{name: "whitespace only under limit", text: "   ", maxCharacters: 5, expected: []string{"   "}},
+		{name: "whitespace only over limit", text: "          ", maxCharacters: 4, expected: []string{"    ", "    ", "  "}},
+		{name: "sentences", text: "One two. Three four! Five six?", maxCharacters: 12, expected: []string{"One two.", "Three four!", "Five six?"}},
+		{name: "sentences packed", text: "One. Two. Three.", maxCharacters: 10, expected: []string{"One. Two.", "Three."}},
+		{name: "new line is a sentence boundary", text: "one two\nthree four", maxCharacters: 10, expected: []string{"one two", "three four"}},
+		{name: "long sentence split at spaces", text: "one two three four five", maxCharacters: 9, expected: []string{"one two", "three", "four five"}},
+		{name: "one long word", text: "abcdefghij", maxCharacters: 4, expected: []string{"abcd", "efgh", "ij"}},
+		{name: "long word inside a sentence", text: "a abcdefgh b", maxCharacters: 4, expected: []string{"a", "abcd", "efgh", "b"}},
+		{name: "CJK punctuation with spaces", text: "你好世界。 再见朋友！ 谢谢", maxCharacters: 6, expected: []string{"你好世界。", "再见朋友！", "谢谢"}},
+		{name: "CJK without spaces", text: "你好世界。再见朋友！", maxCharacters: 4, expected: []string{"你好世界", "。再见朋", "友！"}},
+		{name: "leading and trailing whitespace", text: "  one two.  three four.  ", maxCharacters: 11, expected: []string{"  one two.", "three four."}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			pieces := splitTexts([]string{test.text}, test.maxCharacters)
+			texts := make([]string, len(pieces))
+			var joined strings.Builder
+			for i, piece := range pieces {
+				if piece.index != 0 {
+					t.Errorf("piece %d: index %d", i, piece.index)
+				}
+				if test.maxCharacters > 0 && utf8.RuneCountInString(piece.text) > test.maxCharacters {
+					t.Errorf("piece %d: %q is longer than %d", i, piece.text, test.maxCharacters)
+				}
+				texts[i] = piece.text
+				joined.WriteString(piece.text + piece.separator)
+			}
+			if !reflect.DeepEqual(texts, test.expected) {
+				t.Errorf("pieces %q, expected %q", texts, test.expected)
+			}
+			if joined.String() != test.text {
+				t.Errorf("joined pieces %q, expected %q", joined.String(), test.text)
+			}
+		})
+	}
+}
+
+func TestSplitTextsIndexes(t *testing.T) {
+	pieces := splitTexts([]string{"short", "one two three", "x"}, 5)
+	var indexes []int
+	for _, piece := range pieces {
+		indexes = append(indexes, piece.index)
+	}
+	if expected := []int{0, 1, 1, 1, 2}; !reflect.DeepEqual(indexes, expected) {
+		t.Errorf("indexes %v, expected %v", indexes, expected)
+	}
+}
+
+func TestChunkPieces(t *testing.T) {
+	pieces := func(texts ...string) []textPiece {
+		result := make([]textPiece, len(texts))
+		for i, text := range texts {
+			result[i] = textPiece{index: i, text: text}
+		}
+		return result
+	}
+	tests := []struct {
+		name     string
+		pieces   []textPiece
+		limits   TranslateLimits
+		expected [][]string
+	}{
+		{name: "no limits", pieces: pieces("a", "b", "c"), expected: [][]string{{"a", "b", "c"}}},
+		{name: "max texts", pieces: pieces("a", "b", "c", "d", "e"), limits: TranslateLimits{MaxTexts: 2}, expected: [][]string{{"a", "b"}, {"c", "d"}, {"e"}}},
+		{name: "max characters", pieces: pieces("aaa", "bb", "c", "dddd"), limits: TranslateLimits{MaxCharacters: 4}, expected: [][]string{{"aaa"}, {"bb", "c"}, {"dddd"}}},
+		{name: "characters are runes", pieces: pieces("ёж", "да"), limits: TranslateLimits{MaxCharacters: 4}, expected: [][]string{{"ёж", "да"}}},
+		{name: "both limits", pieces: pieces("a", "b", "c", "dddd"), limits: TranslateLimits{MaxCharacters: 4, MaxTexts: 2}, expected: [][]string{{"a", "b"}, {"c"}, {"dddd"}}},
+		{name: "empty", pieces: nil, limits: TranslateLimits{MaxTexts: 2}, expected: nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var chunks [][]string
+			for _, chunk := range chunkPieces(test.pieces, test.limits) {
+				texts := make([]string, len(chunk))
+				for i, piece := range chunk {
+					texts[i] = piece.text
+				}
+				chunks = append(chunks, texts)
+			}
+			if !reflect.DeepEqual(chunks, test.expected) {
+				t.Errorf("chunks %q, expected %q", chunks, test.expected)
+			}
+		})
+	}
+}
+
+// upperTranslator translates a text to upper case and records the requests
+type upperTranslator struct {
+	mu       sync.Mutex
+	requests [][]string
+	err      error
+}
+
+func (u *upperTranslator) translate(_ context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	u.mu.Lock()
+	u.requests = append(u.requests, request.Texts)
+	u.mu.Unlock()
+	if u.err != nil {
+		return nil, u.err
+	}
+	resp := &TranslateResponse{Translations: make([]Translation, len(request.Texts))}
+	for i, text := range request.Texts {
+		resp.Translations[i] = Translation{Text: strings.ToUpper(text), DetectedLanguageCode: "en"}
+	}
+	return resp, nil
+}
+
+func TestTranslateChunked(t *testing.T) {
+	tests := []struct {
+		name     string
+		texts    []string
+		limits   TranslateLimits
+		requests int
+	}{
+		{name: "no split", texts: []string{"one", "two"}, limits: TranslateLimits{MaxCharacters: 10, MaxTexts: 10}, requests: 1},
+		{name: "max texts", texts: []string{"a", "b", "c", "d", "e"}, limits: TranslateLimits{MaxTexts: 2}, requests: 3},
+		{name: "split texts", texts: []string{"one two. three four.\nfive", "six", "  seven  "}, limits: TranslateLimits{MaxCharacters: 9, Parallelism: 2}, requests: 5},
+		{name: "split texts by one", texts: []string{"one two. three four.", "abcdefghijkl"}, limits: TranslateLimits{MaxCharacters: 5, MaxTexts: 1, Parallelism: 1}, requests: 7},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			upper := &upperTranslator{}
+			resp, err := translateChunked(context.Background(), &TranslateRequest{Texts: test.texts, TargetLanguageCode: "de"}, test.limits, upper.translate)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(upper.requests) != test.requests {
+				t.Errorf("requests %q, expected %d", upper.requests, test.requests)
+			}
+			for _, texts := range upper.requests {
+				if test.limits.MaxTexts > 0 && len(texts) > test.limits.MaxTexts {
+					t.Errorf("request %q exceeds max texts %d", texts, test.limits.MaxTexts)
+				}
+				characters := 0
+				for _, text := range texts {
+					characters += utf8.RuneCountInString(text)
+				}
+				if test.limits.MaxCharacters > 0 && characters > test.limits.MaxCharacters {
+					t.Errorf("request %q exceeds max characters %d", texts, test.limits.MaxCharacters)
+				}
+			}
+			if len(resp.Translations) != len(test.texts) {
+				t.Fatalf("translations %v, expected %d", resp.Translations, len(test.texts))
+			}
+			for i, text := range test.texts {
+				if expected := (Translation{Text: strings.ToUpper(text), DetectedLanguageCode: "en"}); resp.Translations[i] != expected {
+					t.Errorf("translation %d: %+v, expected %+v", i, resp.Translations[i], expected)
+				}
+			}
+		})
+	}
+}
+
+func TestTranslateChunkedDetectedLanguageOfFirstPiece(t *testing.T) {
+	translate := func(_ context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+		resp := &TranslateResponse{Translations: make([]Translation, len(request.Texts))}
+		for i, text := range request.Texts {
+			resp.Translations[i] = Translation{Text: text, DetectedLanguageCode: text}
+		}
+		return resp, nil
+	}
+	resp, err := translateChunked(context.Background(), &TranslateRequest{Texts: []string{"fr. de."}}, TranslateLimits{MaxCharacters: 3, MaxTexts: 1}, translate)
+	if err != nil {
+		t.Fatal(err)
+	} else if expected := (Translation{Text: "fr. de.", DetectedLanguageCode: "fr."}); resp.Translations[0] != expected {
+		t.Errorf("translation %+v, expected %+v", resp.Translations[0], expected)
+	}
+}
+
+func TestTranslateChunkedError(t *testing.T) {
+	upstreamErr := errors.New("upstream failed")
+	upper := &upperTranslator{err: upstreamErr}
+	_, err := translateChunked(context.Background(), &TranslateRequest{Texts: []string{"a", "b", "c"}}, TranslateLimits{MaxTexts: 1}, upper.translate)
+	if !errors.Is(err, upstreamErr) {
+		t.Errorf("error %v, expected %v", err, upstreamErr)
+	}
+
+	short := func(_ context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+		return &TranslateResponse{}, nil
+	}
+	if _, err := translateChunked(context.Background(), &TranslateRequest{Texts: []string{"a", "b"}}, TranslateLimits{MaxTexts: 1}, short); err == nil {
+		t.Error("missing translations are not reported")
+	}
+}
@@ -1,21 +1,28 @@
 package main
 
 import (
+	"context"
 	"crypto/tls"
 	"encoding/json"
 	"errors"
 	"flag"
 	"fmt"
 	"io/ioutil"
-	"log"
+	"mime"
 	"net/http"
 	"os"
+	"os/signal"
 	"path"
+	"reflect"
 	"strings"
+	"sync/atomic"
+	"syscall"
+	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
 	"github.com/m4gshm/gollections/slice"
+	"golang.org/x/exp/slog"
 )
 
 const (
@@ -23,37 +30,78 @@ const (
 )
 
 var (
-	configFile    = flag.String("config-file", "", "Configuration file")
-	newFolderName = flag.String("new-folder-name", name, "New cloud folder name")
-	allFolders    = flag.Bool("all-folders", false, "Don't explore only active cloud folders")
-	oAuthTokenURL = flag.String("oauth-token-url", "https://oauth.yandex.ru/authorize/?response_type=token&client_id=1a6990aa636648e9b2ef855fa7bec2fb", "OAuth token URL")
-	iamTokenURL   = flag.String("iam-token-url", "https://iam.api.cloud.yandex.net/iam/v1/tokens", "IAM token URL")
-	cloudsURL     = flag.String("clouds-url", "https://resource-manager.api.cloud.yandex.net/resource-manager/v1/clouds", "Yandex Clouds URL")
-	foldersURL    = flag.String("cloud-folders-url", "https://resource-manager.api.cloud.yandex.net/resource-manager/v1/folders", "Yandex Cloud folders URL")
-	translateURL  = flag.String("translate-url", "https://translate.api.cloud.yandex.net/translate/v2/translate", "Yandex Translate API URL")
-	address       = flag.String("address", "localhost:8080", "http server address")
-	insecure      = flag.Bool("insecure", false, "disable server certs verifying")
-	accesslog     = flag.Bool("accesslog", false, "enable access log")
-	tlsCertFile   = flag.String("tls-cert-file", "", "tls cert file")
-	tlsKeyFile    = flag.String("tls-key-file", "", "tls key file")
+	configFile            = flag.String("config-file", "", "Configuration file")
+	configReloadInterval  = flag.Duration("config-reload-interval", 10*time.Second, "how often the config file is checked for changes to reload it, 0 disables the check; SIGHUP reloads the config anyway")
+	newFolderName         = flag.String("new-folder-name", name, "New cloud folder name")
+	oAuthToken            = flag.String("oauth-token", "", "OAuth token, overrides the configured one; the config file is not written if it is set")
+	saKeyFile             = flag.String("service-account-key-file", "", "service account authorized key file, overrides the configured one; it is used instead of the OAuth token")
+	cloudID               = flag.String("cloud-id", "", "cloud ID to select a folder from")
+	folderID              = flag.String("folder-id", "", "cloud folder ID, overrides the configured one")
+	nonInteractive        = flag.Bool("non-interactive", false, "fail instead of asking for missing values")
+	allFolders            = flag.Bool("all-folders", false, "Don't explore only active cloud folders")
+	oAuthTokenURL         = flag.String("oauth-token-url", "https://oauth.yandex.ru/authorize/?response_type=token&client_id=1a6990aa636648e9b2ef855fa7bec2fb", "OAuth token URL")
+	iamTokenURL           = flag.String("iam-token-url", "https://iam.api.cloud.yandex.net/iam/v1/tokens", "IAM token URL")
+	iamTokenRefreshBefore = flag.Duration("iam-token-refresh-before", time.Hour, "how long before expiration the IAM token is refreshed in background")
+	cloudsURL             = flag.String("clouds-url", "https://resource-manager.api.cloud.yandex.net/resource-manager/v1/clouds", "Yandex Clouds URL")
+	foldersURL            = flag.String("cloud-folders-url", "https://resource-manager.api.cloud.yandex.net/resource-manager/v1/folders", "Yandex Cloud folders URL")
+	translateURL          = flag.String("translate-url", "https://translate.api.cloud.yandex.net/translate/v2/translate", "Yandex Translate API URL")
+	detectURL             = flag.String("detect-url", "https://translate.api.cloud.yandex.net/translate/v2/detect", "Yandex Translate detect language API URL")
+	languagesURL          = flag.String("languages-url", "https://translate.api.cloud.yandex.net/translate/v2/languages", "Yandex Translate supported languages API URL")
+	translateMaxChars     = flag.Int("translate-max-characters", 10000, "max characters of an upstream translate request, bigger requests are split, 0 means no limit")
+	translateMaxTexts     = flag.Int("translate-max-texts", 100, "max texts of an upstream translate request, bigger requests are split, 0 means no limit")
+	translateParallelism  = flag.Int("translate-parallelism", 4, "max parallel upstream requests of a split translate request")
+	retryMaxAttempts      = flag.Int("retry-max-attempts", 3, "max attempts of an idempotent upstream request failed by throttling, 5xx status or a connection error")
+	retryInitialBackoff   = flag.Duration("retry-initial-backoff", 200*time.Millisecond, "delay before the first retry, doubled every next one")
+	retryMaxBackoff       = flag.Duration("retry-max-backoff", 5*time.Second, "max delay between retries, a longer upstream Retry-After is returned to the client")
+	upstreamTimeout       = flag.Duration("upstream-timeout", 30*time.Second, "max duration of an upstream call including its retries, 0 means no limit")
+	address               = flag.String("address", "localhost:8080", "http server address")
+	insecure              = flag.Bool("insecure", false, "disable server certs verifying")
+	accesslog             = flag.Bool("accesslog", false, "enable access log")
+	logLevelName          = flag.String("log-level", "info", "minimal log level: debug, info, warn or error")
+	logFormat             = flag.String("log-format", logFormatText, "log format: text or json")
+	logPrivacy            = flag.Bool("log-privacy", true, "log only hashes, lengths and language pairs of upstream payloads instead of texts")
+	logPayloadSampleRate  = flag.Float64("log-payload-sample-rate", 0.01, "fraction of upstream payloads logged in full at the debug level when the privacy is disabled")
+	metricsAddress        = flag.String("metrics-address", "localhost:9464", "Prometheus metrics http server address, empty disables the metrics server")
+	tlsCertFile           = flag.String("tls-cert-file", "", "tls cert file")
+	tlsKeyFile            = flag.String("tls-key-file", "", "tls key file")
+	shutdownTimeout       = flag.Duration("shutdown-timeout", 25*time.Second, "how long the shutdown waits for in-flight requests")
+	shutdownDelay         = flag.Duration("shutdown-delay", 0, "how long the proxy reports not ready on shutdown before it stops accepting connections")
+	cacheSize             = flag.Int("cache-size", 10000, "translations memory cache size, 0 disables the cache")
+	cacheTTL              = flag.Duration("cache-ttl", 24*time.Hour, "translations cache entry time to live, 0 means no expiration")
+	cacheDir              = flag.String("cache-dir", "", "translations disk cache directory, empty disables the disk cache")
+	cacheDiskSize         = flag.Int("cache-disk-size", 100000, "translations disk cache size")
+	batchWindow           = flag.Duration("batch-window", 0, "how long concurrent translate requests of the same languages wait to be merged into one upstream request, 0 disables merging")
+	poolStrategy          = flag.String("pool-strategy", poolRoundRobin, "how calls are spread across upstream accounts: round-robin, weighted or cheapest-first")
+	poolEjection          = flag.Duration("pool-ejection", time.Minute, "how long an upstream account rejecting calls by 401, 403 or 429 is out of rotation")
+	batchMaxTexts         = flag.Int("batch-max-texts", 100, "max texts of a merged translate request")
+	batchTimeout          = flag.Duration("batch-timeout", 30*time.Second, "max duration of a merged translate request upstream call, 0 means no limit")
+	charLimit             = flag.Int64("usage-character-limit", 1000000000000, "characters limit reported by usage endpoints")
 )
 
 func usage() {
 	_, _ = fmt.Fprintf(os.Stderr, "Usage of "+name+":\n")
 	_, _ = fmt.Fprintf(os.Stderr, "\t"+name+" [flags]\n")
+	_, _ = fmt.Fprintf(os.Stderr, "\t"+name+" [flags] config print\tprints the effective configuration with masked secrets\n")
 	_, _ = fmt.Fprintf(os.Stderr, "Flags:\n")
 	flag.PrintDefaults()
+	_, _ = fmt.Fprintf(os.Stderr, "Every flag can be set by the environment variable %s<FLAG_NAME>, for example %s\n", envPrefix, envName("oauth-token"))
+	_, _ = fmt.Fprintf(os.Stderr, "or by the settings section of the config file, for example settings: {address: localhost:8080}\n")
+	_, _ = fmt.Fprintf(os.Stderr, "Precedence: flags > environment variables > config file > defaults\n")
 }
 
 func main() {
 	if err := run(); err != nil {
-		log.Fatal(err.Error())
+		slog.Error("exit", err)
+		os.Exit(1)
 	}
 }
 
 func run() error {
 	flag.Usage = usage
 	flag.Parse()
+	if err := applyEnv(); err != nil {
+		return err
+	}
 
 	writeableConfig := false
 	if configFile == nil || len(*configFile) == 0 {
@@ -68,18 +116,59 @@ func run() error {
 	config, err := ReadConfig(*configFile)
 	if err != nil {
 		return fmt.Errorf("read config file: %w", err)
+	} else if err := applySettings(config.Settings); err != nil {
+		return fmt.Errorf("config file %s: %w", *configFile, err)
 	}
 	loadedConfig := *config
+	if len(*oAuthToken) > 0 {
+		//credentials passed by flag or environment must not be persisted
+		writeableConfig = false
+	}
+	overrideConfig(config)
+	if err := validateConfig(config); err != nil {
+		return err
+	} else if err := setupLogging(*logFormat, *logLevelName); err != nil {
+		return err
+	}
+	switch args := flag.Args(); {
+	case len(args) == 0:
+	case len(args) == 2 && args[0] == "config" && args[1] == "print":
+		return printConfig(config)
+	default:
+		return fmt.Errorf("unknown command %s", strings.Join(args, " "))
+	}
+	serviceAccount := len(config.ServiceAccountKeyFile) > 0
 
 	client := &http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: &tls.Config{InsecureSkipVerify: *insecure},
 		},
 	}
-	yandex, err := NewYandexClient(*configFile, writeableConfig, config, client, *iamTokenURL, *cloudsURL, *foldersURL, *translateURL)
+	//a throttled account is ejected from the pool at once instead of waiting for it
+	throttledFailover := len(config.Accounts) > 0
+	newYandexClient := func(configFile string, writeableConfig bool, config *Config) (*YandexClient, error) {
+		return NewYandexClient(configFile, writeableConfig, config, client, *iamTokenURL, *cloudsURL, *foldersURL, *translateURL, *detectURL, *languagesURL, *iamTokenRefreshBefore, TranslateLimits{
+			MaxCharacters: *translateMaxChars,
+			MaxTexts:      *translateMaxTexts,
+			Parallelism:   *translateParallelism,
+		}, RetryPolicy{
+			MaxAttempts:       *retryMaxAttempts,
+			InitialBackoff:    *retryInitialBackoff,
+			MaxBackoff:        *retryMaxBackoff,
+			Timeout:           *upstreamTimeout,
+			ThrottledFailover: throttledFailover,
+		})
+	}
+	yandex, err := newYandexClient(*configFile, writeableConfig, config)
+	if err != nil {
+		return fmt.Errorf("yandex client: %w", err)
+	}
 	checkedOAuth := false
 	for !checkedOAuth {
-		if len(config.OAuthToken) == 0 {
+		if len(config.OAuthToken) == 0 && !serviceAccount {
+			if *nonInteractive {
+				return fmt.Errorf("OAuth token is not defined, use the oauth-token flag or the %s environment variable, or the service-account-key-file flag", envName("oauth-token"))
+			}
 			fmt.Println("Please go to", *oAuthTokenURL)
 			fmt.Println("in order to obtain OAuth token.")
 			fmt.Print("Please enter OAuth token: ")
@@ -88,14 +177,10 @@ func run() error {
 			}
 		}
 
-		if err != nil {
-			return fmt.Errorf("yandex client: %w", err)
-		}
-
 		//requests iam token for oauth checking
 		if _, err := yandex.GetIamToken(); err != nil {
 			var statusErr *HTTPStatusError
-			if errors.As(err, &statusErr) && statusErr.Code == http.StatusUnauthorized {
+			if errors.As(err, &statusErr) && statusErr.Code == http.StatusUnauthorized && !serviceAccount && !*nonInteractive {
 				config.OAuthToken = ""
 			} else {
 				return err
@@ -105,52 +190,236 @@ func run() error {
 		}
 	}
 
-	if folderID, err := selectFolder(yandex, config.FolderID); err != nil {
+	//a folder passed explicitly or to a headless proxy is never replaced by another one
+	reselect := len(*folderID) == 0 && !*nonInteractive
+	if folderID, err := selectFolder(yandex, config.FolderID, reselect); err != nil {
 		return err
 	} else {
 		config.FolderID = folderID
 	}
 
-	if writeableConfig && loadedConfig != *config {
+	if writeableConfig && !reflect.DeepEqual(loadedConfig, *config) {
 		storedConfig := *config
 		storedConfig.Store(*configFile)
 	}
+	//registered after the interactive setup to let Ctrl+C interrupt it
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	pool := NewUpstreamPool(*poolStrategy, *poolEjection)
+	pool.Add(defaultAccountName, yandex, config.Weight, config.Cost)
+	for _, account := range config.Accounts {
+		//tokens of additional accounts are not persisted
+		accountClient, err := newYandexClient("", false, &Config{OAuthToken: account.OAuthToken, ServiceAccountKeyFile: account.ServiceAccountKeyFile, FolderID: account.FolderID})
+		if err != nil {
+			return fmt.Errorf("account %s: %w", account.Name, err)
+		} else if _, err := accountClient.GetIamToken(); err != nil {
+			//the account stays in the pool, the background refresh requests its token again
+			yandexLog.Error("account IAM token", err, "account", account.Name)
+		}
+		pool.Add(account.Name, accountClient, account.Weight, account.Cost)
+	}
+	go pool.RunIamTokenRefresh(ctx)
 
-	server := newServer(yandex, *address, *accesslog)
-	if tlsCertFile != nil && len(*tlsCertFile) > 0 && tlsKeyFile != nil && len(*tlsKeyFile) > 0 {
-		fmt.Printf("Start TLS listening %s\n", *address)
-		return server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
-	} else {
-		fmt.Printf("Start listening %s\n", *address)
-		return server.ListenAndServe()
+	translator, err := selectTranslator(config.Translator, pool)
+	if err != nil {
+		return err
 	}
+	if *batchWindow > 0 {
+		batching := NewBatchingTranslator(translator, *batchWindow, *batchMaxTexts, *batchTimeout)
+		//cancels the batches of the requests interrupted by the shutdown timeout
+		defer batching.Close()
+		translator = batching
+	}
+	if *cacheSize > 0 || len(*cacheDir) > 0 {
+		if translator, err = NewCachedTranslator(translator, *cacheSize, *cacheDir, *cacheDiskSize, *cacheTTL); err != nil {
+			return err
+		}
+	}
+
+	if len(config.APIKeys) == 0 {
+		serverLog.Warn("no API keys configured, the proxy serves anyone who can reach the address", "address", *address)
+	}
+	glossaries, err := LoadGlossaries(config.Glossaries)
+	if err != nil {
+		return err
+	}
+	handler := NewHandler(translator, pool, config.APIKeys, config.RateLimits, glossaries, *charLimit)
+	go NewConfigReloader(*configFile, yandex, handler).Run(ctx, *configReloadInterval)
+	var metricsServer *http.Server
+	if len(*metricsAddress) > 0 {
+		metricsServer = newMetricsServer(*metricsAddress)
+		go func() {
+			serverLog.Info("start metrics listening", "address", *metricsAddress)
+			if err := metricsServer.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+				serverLog.Error("metrics server", err)
+			}
+		}()
+	}
+	health := NewHealthChecker(yandex, pool)
+	server := newServer(handler, health, *address, *accesslog)
+	served := make(chan error, 1)
+	go func() {
+		if len(*tlsCertFile) > 0 {
+			serverLog.Info("start TLS listening", "address", *address)
+			served <- server.ListenAndServeTLS(*tlsCertFile, *tlsKeyFile)
+		} else {
+			serverLog.Info("start listening", "address", *address)
+			served <- server.ListenAndServe()
+		}
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	//the next signal kills the process without waiting
+	stop()
+	return shutdown(server, metricsServer, health, yandex)
 }
 
-func newServer(yandex *YandexClient, addr string, accesslog bool) *http.Server {
+// shutdown stops accepting connections, waits for the in-flight requests up to the shutdown timeout and persists the token state
+func shutdown(server, metricsServer *http.Server, health *HealthChecker, yandex *YandexClient) error {
+	serverLog.Info("shutdown", "delay", *shutdownDelay, "timeout", *shutdownTimeout)
+	health.Drain()
+	//gives load balancers time to notice the not ready state before the listener is closed
+	time.Sleep(*shutdownDelay)
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	err := server.Shutdown(ctx)
+	if err != nil {
+		serverLog.Warn("in-flight requests are interrupted by the shutdown timeout")
+		_ = server.Close()
+		err = fmt.Errorf("shutdown: %w", err)
+	}
+	if metricsServer != nil {
+		_ = metricsServer.Close()
+	}
+	yandex.Flush(ctx)
+	serverLog.Info("shutdown complete")
+	return err
+}
+
+// overrideConfig applies the credentials and the folder passed by flags or environment variables
+func overrideConfig(config *Config) {
+	if len(*oAuthToken) > 0 {
+		config.OAuthToken = *oAuthToken
+	}
+	if len(*saKeyFile) > 0 {
+		config.ServiceAccountKeyFile = *saKeyFile
+	}
+	if len(*folderID) > 0 {
+		config.FolderID = *folderID
+	}
+}
+
+func newServer(handler *Handler, health *HealthChecker, addr string, accesslog bool) *http.Server {
 	r := chi.NewRouter()
+	r.Use(measureRequests)
+	r.Use(middleware.RequestID, requestIDHeader)
 	if accesslog {
-		r.Use(middleware.Logger)
+		r.Use(accessLog)
 	}
 	r.Use(middleware.Recoverer)
-	handler := NewHandler(yandex)
+	//authentication and rate limit errors are written in the format of the emulated API
+	v1_5Errors := func(response http.ResponseWriter, request *http.Request, err error) {
+		callback, _ := parseV1_5Form(request)
+		writeV1_5Error(response, request, callback, err)
+	}
 	r.Route("/", func(r chi.Router) {
 		r.HandleFunc("/", handler.Default)
-		r.Post("/", handler.Post)
+		r.Get("/healthz", healthz)
+		if health != nil {
+			r.Get("/readyz", health.Readyz)
+		}
+		r.With(handler.authenticate(writeError), handler.rateLimit(writeError)).Post("/", handler.Post)
 		//old yandex translate emulation
-		r.Route("/api/v1.5/tr.json/translate", func(r chi.Router) {
-			r.Options("/", handler.v1_5Options)
-			r.Get("/", handler.v1_5Get)
+		r.Route("/api/v1.5/tr.json", func(r chi.Router) {
+			r.Use(handler.authenticate(v1_5Errors), handler.rateLimit(v1_5Errors))
+			r.Options("/*", handler.Options)
+			r.Get("/translate", handler.v1_5Translate)
+			r.Post("/translate", handler.v1_5Translate)
+			r.Get("/detect", handler.v1_5Detect)
+			r.Post("/detect", handler.v1_5Detect)
+			r.Get("/getLangs", handler.v1_5GetLangs)
+			r.Post("/getLangs", handler.v1_5GetLangs)
+		})
+		//google cloud translation v2 emulation
+		r.Route("/language/translate/v2", func(r chi.Router) {
+			r.Use(handler.authenticate(writeGoogleError), handler.rateLimit(writeGoogleError))
+			r.Options("/*", handler.Options)
+			r.Get("/", handler.googleTranslate)
+			r.Post("/", handler.googleTranslate)
+			r.Get("/detect", handler.googleDetect)
+			r.Post("/detect", handler.googleDetect)
+			r.Get("/languages", handler.googleLanguages)
+			r.Post("/languages", handler.googleLanguages)
+		})
+		//deepl api emulation
+		r.Route("/v2", func(r chi.Router) {
+			r.Use(handler.authenticate(writeDeepLError), handler.rateLimit(writeDeepLError))
+			r.Options("/*", handler.Options)
+			r.Get("/translate", handler.deepLTranslate)
+			r.Post("/translate", handler.deepLTranslate)
+			r.Get("/languages", handler.deepLLanguages)
+			r.Post("/languages", handler.deepLLanguages)
+			r.Get("/usage", handler.deepLUsage)
+			r.Post("/usage", handler.deepLUsage)
+		})
+		//libretranslate api emulation
+		r.Group(func(r chi.Router) {
+			r.Use(handler.authenticate(writeLibreTranslateError), handler.rateLimit(writeLibreTranslateError))
+			r.Options("/translate", handler.Options)
+			r.Get("/translate", handler.libreTranslateTranslate)
+			r.Post("/translate", handler.libreTranslateTranslate)
+			r.Options("/detect", handler.Options)
+			r.Get("/detect", handler.libreTranslateDetect)
+			r.Post("/detect", handler.libreTranslateDetect)
+			r.Options("/languages", handler.Options)
+			r.Get("/languages", handler.libreTranslateLanguages)
 		})
 	})
 	return &http.Server{Addr: addr, Handler: r}
 }
 
-func NewHandler(yandex *YandexClient) *Handler {
-	return &Handler{yandex: yandex}
+func NewHandler(translator Translator, counter CharacterCounter, apiKeys []APIKey, limits RateLimits, glossaries *Glossaries, characterLimit int64) *Handler {
+	h := &Handler{translator: translator, counter: counter, characterLimit: characterLimit}
+	h.Reload(apiKeys, limits)
+	h.ReloadGlossaries(glossaries)
+	return h
 }
 
 type Handler struct {
-	yandex *YandexClient
+	translator Translator
+	counter    CharacterCounter
+	access     atomic.Pointer[clientAccess]
+	//nil means no server-side glossaries
+	glossaries     atomic.Pointer[Glossaries]
+	characterLimit int64
+}
+
+// clientAccess restricts clients, it is replaced as a whole by a config reload
+type clientAccess struct {
+	//empty means no authentication
+	apiKeys []APIKey
+	//nil means no rate limits
+	limiter *RateLimiter
+}
+
+// Reload swaps the client restrictions, the rate limiter keeps its state if the limits are not changed
+func (h *Handler) Reload(apiKeys []APIKey, limits RateLimits) {
+	access := &clientAccess{apiKeys: apiKeys}
+	if current := h.access.Load(); current != nil && current.limiter != nil && current.limiter.limits == limits {
+		access.limiter = current.limiter
+	} else if limits != (RateLimits{}) {
+		access.limiter = NewRateLimiter(limits)
+	}
+	h.access.Store(access)
+}
+
+// ReloadGlossaries swaps the server-side glossaries
+func (h *Handler) ReloadGlossaries(glossaries *Glossaries) {
+	h.glossaries.Store(glossaries)
 }
 
 func (h *Handler) Default(response http.ResponseWriter, request *http.Request) {
@@ -159,75 +428,52 @@ func (h *Handler) Default(response http.ResponseWriter, request *http.Request) {
 	_, _ = response.Write([]byte("ok"))
 }
 
+func (h *Handler) Options(response http.ResponseWriter, request *http.Request) {
+	cors(response)
+	response.Header().Add("Allow", "GET,POST,OPTIONS")
+	response.WriteHeader(http.StatusOK)
+}
+
 func (h *Handler) Post(response http.ResponseWriter, request *http.Request) {
 	payload, err := extractTranslateRequest(request)
 	if err != nil {
-		writeError(response, err)
-	} else if result, err := h.translate(payload); err != nil {
-		writeError(response, err)
-	} else if body, err := json.Marshal(result); err != nil {
-		writeError(response, err)
+		writeError(response, request, err)
+	} else if result, err := h.translate(request.Context(), payload); err != nil {
+		writeError(response, request, err)
 	} else {
-		cors(response)
-		response.WriteHeader(http.StatusOK)
-		if _, err := response.Write(body); err != nil {
-			writeError(response, err)
-		}
+		writeJSON(response, http.StatusOK, result)
 	}
 }
 
-func (h *Handler) v1_5Options(response http.ResponseWriter, request *http.Request) {
-	response.Header().Add("Allow", "GET,OPTIONS")
-	response.WriteHeader(http.StatusOK)
-}
-
-func (h *Handler) v1_5Get(response http.ResponseWriter, request *http.Request) {
-	q := request.URL.Query()
-	text := q.Get("text")
-	lang := q.Get("lang")
-	if srcLang, destLang, err := splitSrcDestLanguages(lang); err != nil {
-		writeError(response, err)
-	} else if result, err := h.translate(&TranslateRequest{
-		Texts:              []string{text},
-		SourceLanguageCode: srcLang,
-		TargetLanguageCode: destLang,
-	}); err != nil {
-		writeError(response, err)
-	} else if body, err := json.Marshal(toV1_5Response(result)); err != nil {
-		writeError(response, err)
-	} else {
-		cors(response)
-		response.WriteHeader(http.StatusOK)
-		if _, err := response.Write(body); err != nil {
-			writeError(response, err)
-		}
+func writeJSON(response http.ResponseWriter, status int, payload any) {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		serverLog.Error("response marshal", err)
+		http.Error(response, "internal error", http.StatusInternalServerError)
+		return
 	}
-}
-
-func writeError(response http.ResponseWriter, err error) {
-	logError(err)
-	http.Error(response, err.Error(), http.StatusBadRequest)
-}
-
-func toV1_5Response(result *TranslateResponse) *V1_5TranslateResponse {
-	return &V1_5TranslateResponse{
-		Text: slice.Convert(result.Translations, func(t Translation) string { return t.Text }),
+	cors(response)
+	response.Header().Set("Content-Type", "application/json; charset=utf-8")
+	response.WriteHeader(status)
+	if _, err := response.Write(body); err != nil {
+		serverLog.Debug("response write", slog.ErrorKey, err)
 	}
 }
 
-type V1_5TranslateResponse struct {
-	Text []string `json:"text"`
+func isJSONRequest(request *http.Request) bool {
+	mediaType, _, _ := mime.ParseMediaType(request.Header.Get("Content-Type"))
+	return mediaType == "application/json"
 }
 
 func extractTranslateRequest(request *http.Request) (*TranslateRequest, error) {
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
-		return nil, fmt.Errorf("request read: %w", err)
+		return nil, newValidationError("request read: %w", err)
 	}
 
 	payload := new(TranslateRequest)
 	if err = json.Unmarshal(body, payload); err != nil {
-		return nil, fmt.Errorf("request unmarshal: %w", err)
+		return nil, newValidationError("request unmarshal: %w", err)
 	}
 
 	payload.SourceLanguageCode = extractLanguage(payload.SourceLanguageCode)
@@ -235,8 +481,24 @@ func extractTranslateRequest(request *http.Request) (*TranslateRequest, error) {
 	return payload, nil
 }
 
-func (h *Handler) translate(payload *TranslateRequest) (*TranslateResponse, error) {
-	return h.yandex.Translate(payload)
+func (h *Handler) translate(ctx context.Context, payload *TranslateRequest) (*TranslateResponse, error) {
+	if err := validateGlossaryConfig(payload.GlossaryConfig); err != nil {
+		return nil, err
+	} else if err := authorizeTranslate(ctx, payload); err != nil {
+		return nil, err
+	} else if err := h.allowTranslate(ctx, payload); err != nil {
+		return nil, err
+	}
+	h.glossaries.Load().attach(ctx, payload)
+	return h.translator.Translate(ctx, payload)
+}
+
+func (h *Handler) detect(ctx context.Context, payload *DetectRequest) (*DetectResponse, error) {
+	return h.translator.Detect(ctx, payload)
+}
+
+func (h *Handler) listLanguages(ctx context.Context, payload *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return h.translator.ListLanguages(ctx, payload)
 }
 
 func extractLanguage(langCountry string) string {
@@ -246,69 +508,21 @@ func extractLanguage(langCountry string) string {
 	return langCountry
 }
 
-func splitSrcDestLanguages(language string) (string, string, error) {
-	if len(language) == 0 {
-		return "", "", fmt.Errorf("empty source-destination languages format (expected SRC-DST)")
-	}
-	if !strings.Contains(language, "-") {
-		return "", "", fmt.Errorf("bad source-destination languages format %s (expected SRC-DST)", language)
-	}
-
-	ls := strings.Split(language, "-")
-
-	if len(ls) != 2 {
-		return "", "", fmt.Errorf("unexpected source-destination languages format %s (expected SRC-DST)", language)
-	}
-	srcLang, destLang := ls[0], ls[1]
-	if len(srcLang) == 0 {
-		return "", "", fmt.Errorf("bad source language: %s", language)
-	}
-	if len(destLang) == 0 {
-		return "", "", fmt.Errorf("bad destination language: %s", language)
-	}
-	return srcLang, destLang, nil
-}
-
 func cors(w http.ResponseWriter) {
 	header := w.Header()
 	header.Set("Access-Control-Allow-Origin", "*")
 	header.Set("Access-Control-Allow-Headers", "Content-Type")
 }
 
-func selectFolder(yandex *YandexClient, folderID string) (string, error) {
+// selectFolder checks the configured folder or selects another one, a missing folder is an error if reselecting is not allowed
+func selectFolder(yandex *YandexClient, folderID string, reselect bool) (string, error) {
 	repeat := true
 	for repeat {
 		repeat = false
 		if len(folderID) == 0 {
-			var cloudID string
-			if clouds, err := yandex.GetClouds(); err != nil {
+			cloudID, err := selectCloud(yandex)
+			if err != nil {
 				return "", err
-			} else if clouds == nil || len(clouds.Clouds) == 0 {
-				return "", errors.New("threre is no cloud for your account. Please create it")
-			} else if len(clouds.Clouds) == 1 {
-				cloud := clouds.Clouds[0]
-				fmt.Printf("cloud %s (id = %s) automatically selected\n", cloud.Name, cloud.ID)
-				cloudID = cloud.ID
-			} else {
-				fmt.Println("Please select cloud to use:")
-				for i, cloud := range clouds.Clouds {
-					n := i + 1
-					fmt.Printf("[%d] cloud%d (id = %s, name = %s)\n", n, n, cloud.ID, cloud.Name)
-				}
-				fmt.Print("Please enter your numeric choice: ")
-				var cloudNum int
-				if _, err := fmt.Scanln(&cloudNum); err != nil {
-					return "", err
-				}
-				for {
-					if cloudNum > 0 && cloudNum <= len(clouds.Clouds) {
-						cloud := clouds.Clouds[cloudNum-1]
-						cloudID = cloud.ID
-						break
-					} else {
-						fmt.Printf("Entered invalid cloud number, must be in the range  %d to %d\n", 1, len(clouds.Clouds))
-					}
-				}
 			}
 
 			if folders, err := yandex.GetCloudFolders(cloudID); err != nil {
@@ -321,7 +535,7 @@ func selectFolder(yandex *YandexClient, folderID string) (string, error) {
 				selectedFolders := folders.Folders
 				onlyActiveFolders := !*allFolders
 				if onlyActiveFolders {
-					selectedFolders = slice.Filter(selectedFolders, func(f Folder) bool { return f.Status == "ACTIVE" })
+					selectedFolders = slice.Filter(selectedFolders, func(f Folder) bool { return f.Status == folderStatusActive })
 				}
 				if len(selectedFolders) == 1 {
 					folder := selectedFolders[0]
@@ -333,27 +547,23 @@ func selectFolder(yandex *YandexClient, folderID string) (string, error) {
 						n := i + 1
 						fmt.Printf("[%d] folder%d (id = %s, name = %s, status = %s)\n", n, n, folder.ID, folder.Name, folder.Status)
 					}
-					fmt.Println("Please enter your numeric choice: ")
-					var folderNum int
-					if _, err := fmt.Scanln(&folderNum); err != nil {
-						return "", err
+					if *nonInteractive {
+						return "", fmt.Errorf("cannot select one of %d folders, use the folder-id flag or the %s environment variable", len(selectedFolders), envName("folder-id"))
 					}
-					for {
-						if folderNum > 0 && folderNum <= len(selectedFolders) {
-							folder := selectedFolders[folderNum-1]
-							folderID = folder.ID
-							break
-						} else {
-							fmt.Printf("Entered invalid folder number, must be in the range  %d to %d\n", 1, len(selectedFolders))
-						}
+					folderNum, err := enterNumber(len(selectedFolders))
+					if err != nil {
+						return "", err
 					}
+					folderID = selectedFolders[folderNum-1].ID
 				}
 			}
 		} else {
 			if _, err := yandex.GetCloudFolder(folderID); err != nil {
 				var statusErr *HTTPStatusError
-				if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
-					logDebugf("configured folder %s not found", folderID)
+				if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound && !reselect {
+					return "", fmt.Errorf("folder %s not found: %w", folderID, err)
+				} else if errors.As(err, &statusErr) && statusErr.Code == http.StatusNotFound {
+					configLog.Debug("configured folder not found", "folder_id", folderID)
 					folderID = ""
 					repeat = true
 				} else {
@@ -365,13 +575,58 @@ func selectFolder(yandex *YandexClient, folderID string) (string, error) {
 	return folderID, nil
 }
 
+func selectCloud(yandex *YandexClient) (string, error) {
+	if len(*cloudID) > 0 {
+		return *cloudID, nil
+	}
+	if clouds, err := yandex.GetClouds(); err != nil {
+		return "", err
+	} else if clouds == nil || len(clouds.Clouds) == 0 {
+		return "", errors.New("threre is no cloud for your account. Please create it")
+	} else if len(clouds.Clouds) == 1 {
+		cloud := clouds.Clouds[0]
+		fmt.Printf("cloud %s (id = %s) automatically selected\n", cloud.Name, cloud.ID)
+		return cloud.ID, nil
+	} else {
+		fmt.Println("Please select cloud to use:")
+		for i, cloud := range clouds.Clouds {
+			n := i + 1
+			fmt.Printf("[%d] cloud%d (id = %s, name = %s)\n", n, n, cloud.ID, cloud.Name)
+		}
+		if *nonInteractive {
+			return "", fmt.Errorf("cannot select one of %d clouds, use the cloud-id flag or the %s environment variable", len(clouds.Clouds), envName("cloud-id"))
+		}
+		cloudNum, err := enterNumber(len(clouds.Clouds))
+		if err != nil {
+			return "", err
+		}
+		return clouds.Clouds[cloudNum-1].ID, nil
+	}
+}
+
+func enterNumber(max int) (int, error) {
+	for {
+		fmt.Print("Please enter your numeric choice: ")
+		var num int
+		if _, err := fmt.Scanln(&num); err != nil {
+			return 0, err
+		} else if num > 0 && num <= max {
+			return num, nil
+		}
+		fmt.Printf("Entered invalid number, must be in the range %d to %d\n", 1, max)
+	}
+}
+
 func createFolder(yandex *YandexClient, cloudID, folderName string) (string, error) {
-	logDebugf("trying to create folder %s", folderName)
+	configLog.Debug("trying to create folder", "folder_name", folderName)
 	resp, err := yandex.CreateCloudFolder(cloudID, folderName)
 	if err != nil {
 		var statusErr *HTTPStatusError
 		if errors.As(err, &statusErr) && statusErr.Code == http.StatusConflict {
-			logDebugf("cannot create folder %s because it conflicts with some one might may be has marked as deleted", folderName)
+			configLog.Debug("cannot create folder because it conflicts with some one might may be has marked as deleted", "folder_name", folderName)
+			if *nonInteractive {
+				return "", fmt.Errorf("folder %s already exists, use the new-folder-name flag or the %s environment variable to set another name", folderName, envName("new-folder-name"))
+			}
 			fmt.Print("Please enter your new folder name: ")
 			if _, err := fmt.Scanln(&folderName); err != nil {
 				return "", err
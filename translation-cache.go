@@ -0,0 +1,331 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// NewCachedTranslator wraps the translator by the memory cache and optional disk cache layers.
+// The disk layer is disabled when the dir is empty.
+func NewCachedTranslator(translator Translator, memorySize int, dir string, diskSize int, ttl time.Duration) (*CachedTranslator, error) {
+	cached := &CachedTranslator{translator: translator, memory: newMemoryCache(memorySize, ttl)}
+	if len(dir) > 0 {
+		disk, err := newDiskCache(dir, diskSize, ttl)
+		if err != nil {
+			return nil, fmt.Errorf("disk cache %s: %w", dir, err)
+		}
+		cached.disk = disk
+	}
+	return cached, nil
+}
+
+type CachedTranslator struct {
+	translator Translator
+	memory     *memoryCache
+	disk       *diskCache
+}
+
+var _ Translator = (*CachedTranslator)(nil)
+
+type cacheKey struct {
+	FolderID           string `json:"folderId"`
+	SourceLanguageCode string `json:"sourceLanguageCode"`
+	TargetLanguageCode string `json:"targetLanguageCode"`
+	Format             string `json:"format,omitempty"`
+	Glossary           string `json:"glossary,omitempty"`
+	Text               string `json:"text"`
+}
+
+func (k cacheKey) hash() string {
+	payload, _ := json.Marshal(k)
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+func (t *CachedTranslator) Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	translations := make([]Translation, len(request.Texts))
+	//positions of missed texts in the request by upstream text index
+	missed := map[string]int{}
+	var missedPositions [][]int
+	var missedTexts []string
+	for i, text := range request.Texts {
+		if translation, ok := t.get(newCacheKey(request, text)); ok {
+			translations[i] = translation
+		} else if m, ok := missed[text]; ok {
+			missedPositions[m] = append(missedPositions[m], i)
+		} else {
+			missed[text] = len(missedTexts)
+			missedTexts = append(missedTexts, text)
+			missedPositions = append(missedPositions, []int{i})
+		}
+	}
+	if len(missedTexts) > 0 {
+		upstreamRequest := *request
+		upstreamRequest.Texts = missedTexts
+		resp, err := t.translator.Translate(ctx, &upstreamRequest)
+		if err != nil {
+			return nil, err
+		} else if len(resp.Translations) != len(missedTexts) {
+			return nil, fmt.Errorf("unexpected translations amount %d, expected %d", len(resp.Translations), len(missedTexts))
+		}
+		for m, translation := range resp.Translations {
+			t.put(newCacheKey(request, missedTexts[m]), translation)
+			for _, i := range missedPositions[m] {
+				translations[i] = translation
+			}
+		}
+	}
+	return &TranslateResponse{Translations: translations}, nil
+}
+
+func (t *CachedTranslator) Detect(ctx context.Context, request *DetectRequest) (*DetectResponse, error) {
+	return t.translator.Detect(ctx, request)
+}
+
+func (t *CachedTranslator) ListLanguages(ctx context.Context, request *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return t.translator.ListLanguages(ctx, request)
+}
+
+func (t *CachedTranslator) get(key cacheKey) (Translation, bool) {
+	if translation, ok := t.memory.get(key); ok {
+		cacheLookups.WithLabelValues("memory_hit").Inc()
+		return translation, true
+	} else if t.disk != nil {
+		if translation, expires, ok := t.disk.get(key); ok {
+			t.memory.putExpires(key, translation, expires)
+			cacheLookups.WithLabelValues("disk_hit").Inc()
+			return translation, true
+		}
+	}
+	cacheLookups.WithLabelValues("miss").Inc()
+	return Translation{}, false
+}
+
+func (t *CachedTranslator) put(key cacheKey, translation Translation) {
+	t.memory.put(key, translation)
+	if t.disk != nil {
+		if err := t.disk.put(key, translation); err != nil {
+			cacheLog.Error("disk cache put", err)
+		}
+	}
+}
+
+func newCacheKey(request *TranslateRequest, text string) cacheKey {
+	return cacheKey{
+		FolderID:           request.FolderID,
+		SourceLanguageCode: request.SourceLanguageCode,
+		TargetLanguageCode: request.TargetLanguageCode,
+		Format:             request.Format,
+		Glossary:           glossaryHash(request),
+		Text:               text,
+	}
+}
+
+type cacheEntry struct {
+	Key         cacheKey    `json:"key"`
+	Translation Translation `json:"translation"`
+	Expires     time.Time   `json:"expires"`
+}
+
+func (e *cacheEntry) isExpired() bool {
+	return !e.Expires.IsZero() && e.Expires.Before(time.Now())
+}
+
+func expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return time.Now().Add(ttl)
+}
+
+// memoryCache is the LRU cache limited by the entries amount.
+type memoryCache struct {
+	mu      sync.Mutex
+	size    int
+	ttl     time.Duration
+	entries map[cacheKey]*list.Element
+	order   *list.List
+}
+
+func newMemoryCache(size int, ttl time.Duration) *memoryCache {
+	return &memoryCache{size: size, ttl: ttl, entries: map[cacheKey]*list.Element{}, order: list.New()}
+}
+
+func (c *memoryCache) get(key cacheKey) (Translation, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	element, ok := c.entries[key]
+	if !ok {
+		return Translation{}, false
+	}
+	entry := element.Value.(*cacheEntry)
+	if entry.isExpired() {
+		c.remove(element)
+		return Translation{}, false
+	}
+	c.order.MoveToFront(element)
+	return entry.Translation, true
+}
+
+func (c *memoryCache) put(key cacheKey, translation Translation) {
+	c.putExpires(key, translation, expiresAt(c.ttl))
+}
+
+func (c *memoryCache) putExpires(key cacheKey, translation Translation, expires time.Time) {
+	if c.size <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if element, ok := c.entries[key]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.Translation = translation
+		entry.Expires = expires
+		c.order.MoveToFront(element)
+		return
+	}
+	c.entries[key] = c.order.PushFront(&cacheEntry{Key: key, Translation: translation, Expires: expires})
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back())
+	}
+}
+
+func (c *memoryCache) remove(element *list.Element) {
+	c.order.Remove(element)
+	delete(c.entries, element.Value.(*cacheEntry).Key)
+}
+
+// diskCache stores every entry in a separate file of the dir and removes the oldest files when the entries amount exceeds the size.
+// Only files named by an entry key hash are cache entries, other files of the dir are never touched.
+type diskCache struct {
+	mu    sync.Mutex
+	dir   string
+	size  int
+	ttl   time.Duration
+	files map[string]*list.Element
+	order *list.List
+}
+
+func newDiskCache(dir string, size int, ttl time.Duration) (*diskCache, error) {
+	if size <= 0 {
+		return nil, fmt.Errorf("size must be positive, actual %d", size)
+	} else if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+		return nil, err
+	}
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	type file struct {
+		name    string
+		modTime time.Time
+	}
+	files := make([]file, 0, len(dirEntries))
+	for _, dirEntry := range dirEntries {
+		if dirEntry.IsDir() || !isCacheEntryName(dirEntry.Name()) {
+			continue
+		} else if info, err := dirEntry.Info(); err != nil {
+			return nil, err
+		} else {
+			files = append(files, file{name: dirEntry.Name(), modTime: info.ModTime()})
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+
+	cache := &diskCache{dir: dir, size: size, ttl: ttl, files: map[string]*list.Element{}, order: list.New()}
+	for _, f := range files {
+		cache.files[f.name] = cache.order.PushBack(f.name)
+	}
+	cache.evict()
+	cacheLog.Debug("disk cache loaded", "dir", dir, "entries", cache.order.Len())
+	return cache, nil
+}
+
+func (c *diskCache) get(key cacheKey) (Translation, time.Time, bool) {
+	name := key.hash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.files[name]; !ok {
+		return Translation{}, time.Time{}, false
+	}
+	payload, err := os.ReadFile(filepath.Join(c.dir, name))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			cacheLog.Error("disk cache read", err)
+		}
+		c.remove(name)
+		return Translation{}, time.Time{}, false
+	}
+	entry := new(cacheEntry)
+	if err := json.Unmarshal(payload, entry); err != nil {
+		cacheLog.Error("disk cache entry unmarshal", err, "entry", name)
+		c.remove(name)
+		return Translation{}, time.Time{}, false
+	} else if entry.Key != key {
+		return Translation{}, time.Time{}, false
+	} else if entry.isExpired() {
+		c.remove(name)
+		return Translation{}, time.Time{}, false
+	}
+	return entry.Translation, entry.Expires, true
+}
+
+func (c *diskCache) put(key cacheKey, translation Translation) error {
+	payload, err := json.Marshal(&cacheEntry{Key: key, Translation: translation, Expires: expiresAt(c.ttl)})
+	if err != nil {
+		return err
+	}
+	name := key.hash()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := os.WriteFile(filepath.Join(c.dir, name), payload, 0o600); err != nil {
+		return err
+	}
+	if element, ok := c.files[name]; ok {
+		c.order.MoveToFront(element)
+	} else {
+		c.files[name] = c.order.PushFront(name)
+	}
+	c.evict()
+	return nil
+}
+
+// isCacheEntryName checks the file is named by cacheKey.hash
+func isCacheEntryName(name string) bool {
+	if len(name) != sha256.Size*2 {
+		return false
+	}
+	for _, r := range name {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func (c *diskCache) evict() {
+	for c.order.Len() > c.size {
+		c.remove(c.order.Back().Value.(string))
+	}
+}
+
+func (c *diskCache) remove(name string) {
+	if element, ok := c.files[name]; ok {
+		c.order.Remove(element)
+		delete(c.files, name)
+	}
+	if err := os.Remove(filepath.Join(c.dir, name)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		cacheLog.Error("disk cache remove", err)
+	}
+}
@@ -0,0 +1,329 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// countingTranslator translates a text to upper case and records the texts sent upstream
+type countingTranslator struct {
+	mu       sync.Mutex
+	requests [][]string
+}
+
+var _ Translator = (*countingTranslator)(nil)
+
+func (c *countingTranslator) Translate(_ context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	c.mu.Lock()
+	c.requests = append(c.requests, request.Texts)
+	c.mu.Unlock()
+	resp := &TranslateResponse{Translations: make([]Translation, len(request.Texts))}
+	for i, text := range request.Texts {
+		resp.Translations[i] = Translation{Text: strings.ToUpper(text)}
+	}
+	return resp, nil
+}
+
+func (c *countingTranslator) Detect(context.Context, *DetectRequest) (*DetectResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *countingTranslator) ListLanguages(context.Context, *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return nil, errors.New("not implemented")
+}
+
+func (c *countingTranslator) texts() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var texts []string
+	for _, request := range c.requests {
+		texts = append(texts, request...)
+	}
+	return texts
+}
+
+func (c *countingTranslator) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.requests = nil
+}
+
+func translateTexts(t *testing.T, translator Translator, texts ...string) []string {
+	t.Helper()
+	resp, err := translator.Translate(context.Background(), &TranslateRequest{FolderID: "folder", TargetLanguageCode: "en", Texts: texts})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result := make([]string, len(resp.Translations))
+	for i, translation := range resp.Translations {
+		result[i] = translation.Text
+	}
+	return result
+}
+
+func upper(texts ...string) []string {
+	result := make([]string, len(texts))
+	for i, text := range texts {
+		result[i] = strings.ToUpper(text)
+	}
+	return result
+}
+
+func TestCachedTranslatorSendsOnlyMissedTexts(t *testing.T) {
+	upstream := &countingTranslator{}
+	cached, err := NewCachedTranslator(upstream, 100, "", 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var cachedTexts, texts []string
+	for i := 0; i < 50; i++ {
+		text := fmt.Sprintf("text %d", i)
+		if i%5 == 0 {
+			cachedTexts = append(cachedTexts, text)
+		}
+		texts = append(texts, text)
+	}
+	translateTexts(t, cached, cachedTexts...)
+	upstream.reset()
+
+	//duplicates of a missed text are sent once
+	texts = append(texts, "text 1", "text 1", "text 5")
+	if translations := translateTexts(t, cached, texts...); !reflect.DeepEqual(translations, upper(texts...)) {
+		t.Errorf("translations %q, expected %q", translations, upper(texts...))
+	}
+	if len(upstream.requests) != 1 {
+		t.Fatalf("upstream requests %d, expected 1", len(upstream.requests))
+	}
+	sent := upstream.texts()
+	if len(sent) != 40 {
+		t.Errorf("upstream texts %d, expected 40: %q", len(sent), sent)
+	}
+	for _, text := range sent {
+		for _, cachedText := range cachedTexts {
+			if text == cachedText {
+				t.Errorf("cached text %q is sent upstream", text)
+			}
+		}
+	}
+
+	upstream.reset()
+	translateTexts(t, cached, texts...)
+	if len(upstream.requests) != 0 {
+		t.Errorf("upstream requests %q, expected none", upstream.requests)
+	}
+}
+
+func TestCachedTranslatorKeyIncludesLanguages(t *testing.T) {
+	upstream := &countingTranslator{}
+	cached, _ := NewCachedTranslator(upstream, 100, "", 0, time.Hour)
+	for _, target := range []string{"en", "de", "en"} {
+		if _, err := cached.Translate(context.Background(), &TranslateRequest{TargetLanguageCode: target, Texts: []string{"hello"}}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(upstream.requests) != 2 {
+		t.Errorf("upstream requests %d, expected 2", len(upstream.requests))
+	}
+}
+
+func TestMemoryCacheEviction(t *testing.T) {
+	cache := newMemoryCache(2, 0)
+	a, b, c := cacheKey{Text: "a"}, cacheKey{Text: "b"}, cacheKey{Text: "c"}
+	cache.put(a, Translation{Text: "A"})
+	cache.put(b, Translation{Text: "B"})
+	//a becomes the most recently used
+	if _, ok := cache.get(a); !ok {
+		t.Fatal("a is missed")
+	}
+	cache.put(c, Translation{Text: "C"})
+	if _, ok := cache.get(b); ok {
+		t.Error("the least recently used b is not evicted")
+	}
+	for _, key := range []cacheKey{a, c} {
+		if _, ok := cache.get(key); !ok {
+			t.Errorf("%s is evicted", key.Text)
+		}
+	}
+	if len(cache.entries) != 2 || cache.order.Len() != 2 {
+		t.Errorf("entries %d, order %d, expected 2", len(cache.entries), cache.order.Len())
+	}
+}
+
+func TestMemoryCacheUpdate(t *testing.T) {
+	cache := newMemoryCache(2, 0)
+	key := cacheKey{Text: "a"}
+	cache.put(key, Translation{Text: "A"})
+	cache.put(key, Translation{Text: "B"})
+	if translation, _ := cache.get(key); translation.Text != "B" || cache.order.Len() != 1 {
+		t.Errorf("translation %q, entries %d", translation.Text, cache.order.Len())
+	}
+}
+
+func TestMemoryCacheTTL(t *testing.T) {
+	cache := newMemoryCache(10, time.Hour)
+	expired, live, eternal := cacheKey{Text: "expired"}, cacheKey{Text: "live"}, cacheKey{Text: "eternal"}
+	cache.putExpires(expired, Translation{Text: "E"}, time.Now().Add(-time.Second))
+	cache.put(live, Translation{Text: "L"})
+	cache.putExpires(eternal, Translation{Text: "F"}, time.Time{})
+	if _, ok := cache.get(expired); ok {
+		t.Error("expired entry is returned")
+	} else if _, ok := cache.entries[expired]; ok {
+		t.Error("expired entry is not removed")
+	}
+	if _, ok := cache.get(live); !ok {
+		t.Error("live entry is missed")
+	}
+	if _, ok := cache.get(eternal); !ok {
+		t.Error("entry without expiration is missed")
+	}
+}
+
+func TestMemoryCacheDisabled(t *testing.T) {
+	cache := newMemoryCache(0, 0)
+	cache.put(cacheKey{Text: "a"}, Translation{Text: "A"})
+	if _, ok := cache.get(cacheKey{Text: "a"}); ok {
+		t.Error("zero size cache returns an entry")
+	}
+}
+
+func TestDiskCacheSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	upstream := &countingTranslator{}
+	cached, err := NewCachedTranslator(upstream, 10, dir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	translateTexts(t, cached, "a", "b")
+
+	upstream.reset()
+	restarted, err := NewCachedTranslator(upstream, 10, dir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if translations := translateTexts(t, restarted, "a", "b", "c"); !reflect.DeepEqual(translations, upper("a", "b", "c")) {
+		t.Errorf("translations %q", translations)
+	}
+	if sent := upstream.texts(); !reflect.DeepEqual(sent, []string{"c"}) {
+		t.Errorf("upstream texts %q, expected only c", sent)
+	}
+
+	//the disk hit is promoted to the memory layer with the disk entry expiration
+	key := newCacheKey(&TranslateRequest{FolderID: "folder", TargetLanguageCode: "en"}, "a")
+	_, diskExpires, ok := restarted.disk.get(key)
+	if !ok {
+		t.Fatal("disk entry is missed")
+	}
+	element, ok := restarted.memory.entries[key]
+	if !ok {
+		t.Fatal("disk hit is not promoted to memory")
+	} else if expires := element.Value.(*cacheEntry).Expires; !expires.Equal(diskExpires) {
+		t.Errorf("memory entry expires %s, expected %s", expires, diskExpires)
+	}
+}
+
+func TestDiskCacheStartupEviction(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newDiskCache(dir, 10, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys := []cacheKey{{Text: "old"}, {Text: "middle"}, {Text: "new"}}
+	for i, key := range keys {
+		if err := cache.put(key, Translation{Text: key.Text}); err != nil {
+			t.Fatal(err)
+		}
+		modTime := time.Now().Add(time.Duration(i-len(keys)) * time.Hour)
+		if err := os.Chtimes(filepath.Join(dir, key.hash()), modTime, modTime); err != nil {
+			t.Fatal(err)
+		}
+	}
+	foreign := []string{"notes.txt", strings.Repeat("A", 64), strings.Repeat("a", 63)}
+	for _, name := range foreign {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("keep"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Mkdir(filepath.Join(dir, strings.Repeat("b", 64)), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err = newDiskCache(dir, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cache.order.Len() != 2 {
+		t.Errorf("entries %d, expected 2", cache.order.Len())
+	}
+	if _, err := os.Stat(filepath.Join(dir, keys[0].hash())); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the oldest entry is not evicted: %v", err)
+	}
+	for _, key := range keys[1:] {
+		if _, _, ok := cache.get(key); !ok {
+			t.Errorf("entry %s is evicted", key.Text)
+		}
+	}
+	for _, name := range append(foreign, strings.Repeat("b", 64)) {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("foreign file %s: %v", name, err)
+		}
+	}
+
+	//eviction on put never touches foreign files either
+	for i := 0; i < 5; i++ {
+		if err := cache.put(cacheKey{Text: fmt.Sprint(i)}, Translation{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != 2+len(foreign)+1 {
+		t.Errorf("dir files %d, expected %d", len(entries), 2+len(foreign)+1)
+	}
+}
+
+func TestDiskCacheRemovesBrokenEntries(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := newDiskCache(dir, 10, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	corrupt, expired := cacheKey{Text: "corrupt"}, cacheKey{Text: "expired"}
+	for _, key := range []cacheKey{corrupt, expired} {
+		if err := cache.put(key, Translation{Text: key.Text}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(dir, corrupt.hash()), []byte("{not json"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	payload, _ := json.Marshal(&cacheEntry{Key: expired, Translation: Translation{Text: "E"}, Expires: time.Now().Add(-time.Minute)})
+	if err := os.WriteFile(filepath.Join(dir, expired.hash()), payload, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []cacheKey{corrupt, expired} {
+		if _, _, ok := cache.get(key); ok {
+			t.Errorf("broken entry %s is returned", key.Text)
+		}
+		if _, err := os.Stat(filepath.Join(dir, key.hash())); !errors.Is(err, os.ErrNotExist) {
+			t.Errorf("broken entry %s is not removed: %v", key.Text, err)
+		}
+		if _, ok := cache.files[key.hash()]; ok {
+			t.Errorf("broken entry %s stays in the index", key.Text)
+		}
+	}
+}
+
+func TestNewDiskCacheRejectsZeroSize(t *testing.T) {
+	if _, err := newDiskCache(t.TempDir(), 0, 0); err == nil {
+		t.Error("zero size is accepted")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"unicode/utf8"
+)
+
+const (
+	yandexTranslator = "yandex"
+)
+
+// Translator is a translation engine behind the emulated APIs.
+type Translator interface {
+	Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error)
+	Detect(ctx context.Context, request *DetectRequest) (*DetectResponse, error)
+	ListLanguages(ctx context.Context, request *ListLanguagesRequest) (*ListLanguagesResponse, error)
+}
+
+// CharacterCounter reports the characters amount sent to a translation engine.
+type CharacterCounter interface {
+	TranslatedCharacters() int64
+}
+
+// languagePair formats the pair as source-target, an empty source is auto detected
+func languagePair(source, target string) string {
+	if len(source) == 0 {
+		source = "auto"
+	}
+	return source + "-" + target
+}
+
+func countCharacters(texts []string) int {
+	count := 0
+	for _, text := range texts {
+		count += utf8.RuneCountInString(text)
+	}
+	return count
+}
+
+func selectTranslator(name string, yandex Translator) (Translator, error) {
+	switch name {
+	case "", yandexTranslator:
+		return yandex, nil
+	default:
+		return nil, fmt.Errorf("unsupported translator %s", name)
+	}
+}
@@ -0,0 +1,258 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"golang.org/x/exp/slog"
+)
+
+const (
+	poolRoundRobin    = "round-robin"
+	poolWeighted      = "weighted"
+	poolCheapestFirst = "cheapest-first"
+	//name of the account of the top-level config credentials and folder
+	defaultAccountName = "default"
+)
+
+// Account is an additional Yandex Cloud credentials and folder pair of the upstream pool
+type Account struct {
+	Name                  string
+	OAuthToken            string `yaml:",omitempty"`
+	ServiceAccountKeyFile string `yaml:",omitempty"`
+	FolderID              string
+	//share of calls by the weighted strategy, 0 means 1
+	Weight int `yaml:",omitempty"`
+	//the cheapest-first strategy prefers accounts with lower cost
+	Cost float64 `yaml:",omitempty"`
+}
+
+func validatePoolStrategy(strategy string) error {
+	switch strategy {
+	case poolRoundRobin, poolWeighted, poolCheapestFirst:
+		return nil
+	default:
+		return fmt.Errorf("unsupported strategy %q, expected %s, %s or %s", strategy, poolRoundRobin, poolWeighted, poolCheapestFirst)
+	}
+}
+
+func validateAccounts(accounts []Account) error {
+	names := map[string]bool{defaultAccountName: true}
+	var errs []error
+	for i, account := range accounts {
+		if len(account.Name) == 0 {
+			errs = append(errs, fmt.Errorf("account %d: empty name", i))
+		} else if names[account.Name] {
+			errs = append(errs, fmt.Errorf("account %s: duplicated name", account.Name))
+		}
+		names[account.Name] = true
+		if len(account.OAuthToken) == 0 && len(account.ServiceAccountKeyFile) == 0 {
+			errs = append(errs, fmt.Errorf("account %s: no OAuth token or service account key file", account.Name))
+		} else if len(account.ServiceAccountKeyFile) > 0 {
+			if _, err := os.Stat(account.ServiceAccountKeyFile); err != nil {
+				errs = append(errs, fmt.Errorf("account %s: %w", account.Name, err))
+			}
+		}
+		if len(account.FolderID) == 0 {
+			errs = append(errs, fmt.Errorf("account %s: empty folder", account.Name))
+		}
+		if account.Weight < 0 || account.Cost < 0 {
+			errs = append(errs, fmt.Errorf("account %s: weight and cost must not be negative", account.Name))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+var (
+	_ Translator       = (*UpstreamPool)(nil)
+	_ CharacterCounter = (*UpstreamPool)(nil)
+)
+
+func NewUpstreamPool(strategy string, ejection time.Duration) *UpstreamPool {
+	return &UpstreamPool{strategy: strategy, ejection: ejection}
+}
+
+// UpstreamPool spreads calls across accounts by the strategy and fails over to the next account.
+// An account rejecting calls by 401, 403 or 429 is taken out of rotation for the ejection time.
+type UpstreamPool struct {
+	strategy string
+	ejection time.Duration
+	mu       sync.Mutex
+	accounts []*poolAccount
+	//round-robin position
+	next int
+}
+
+type poolAccount struct {
+	name   string
+	client *YandexClient
+	weight int
+	cost   float64
+	//smooth weighted round-robin state
+	currentWeight int
+	ejectedUntil  time.Time
+	ejections     int64
+	failures      int64
+	lastError     string
+}
+
+// AccountState describes an account of the pool for monitoring
+type AccountState struct {
+	Name         string    `json:"name"`
+	FolderID     string    `json:"folderId"`
+	Available    bool      `json:"available"`
+	EjectedUntil time.Time `json:"ejectedUntil"`
+	Ejections    int64     `json:"ejections"`
+	Failures     int64     `json:"failures"`
+	LastError    string    `json:"lastError,omitempty"`
+	IamTokenOK   bool      `json:"iamTokenOk"`
+}
+
+// Add must be called before the pool is used
+func (p *UpstreamPool) Add(name string, client *YandexClient, weight int, cost float64) {
+	if weight <= 0 {
+		weight = 1
+	}
+	p.accounts = append(p.accounts, &poolAccount{name: name, client: client, weight: weight, cost: cost})
+}
+
+func (p *UpstreamPool) Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
+	return poolCall(ctx, p, request, (*YandexClient).Translate)
+}
+
+func (p *UpstreamPool) Detect(ctx context.Context, request *DetectRequest) (*DetectResponse, error) {
+	return poolCall(ctx, p, request, (*YandexClient).Detect)
+}
+
+func (p *UpstreamPool) ListLanguages(ctx context.Context, request *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	return poolCall(ctx, p, request, (*YandexClient).ListLanguages)
+}
+
+func (p *UpstreamPool) TranslatedCharacters() int64 {
+	var characters int64
+	for _, account := range p.accounts {
+		characters += account.client.TranslatedCharacters()
+	}
+	return characters
+}
+
+// RunIamTokenRefresh refreshes the IAM tokens of all accounts until the ctx is done
+func (p *UpstreamPool) RunIamTokenRefresh(ctx context.Context) {
+	for _, account := range p.accounts {
+		go account.client.RunIamTokenRefresh(ctx)
+	}
+}
+
+// poolCall tries the accounts in the strategy order until one succeeds or fails by a client's mistake
+func poolCall[Req, Resp any](ctx context.Context, p *UpstreamPool, request *Req, call func(c *YandexClient, ctx context.Context, request *Req) (*Resp, error)) (*Resp, error) {
+	var lastErr error
+	for _, account := range p.order() {
+		//every account fills in its own folder
+		attemptRequest := *request
+		resp, err := call(account.client, ctx, &attemptRequest)
+		if err == nil {
+			return resp, nil
+		} else if !p.failed(ctx, account, err) {
+			return nil, err
+		}
+		lastErr = err
+	}
+	return nil, lastErr
+}
+
+// failed records the account failure and reports whether the next account may succeed
+func (p *UpstreamPool) failed(ctx context.Context, account *poolAccount, err error) bool {
+	var statusErr *HTTPStatusError
+	eject := errors.As(err, &statusErr) && (statusErr.Code == http.StatusUnauthorized ||
+		statusErr.Code == http.StatusForbidden || statusErr.Code == http.StatusTooManyRequests)
+	if !eject && !isTransient(err) {
+		return false
+	}
+	until := time.Now().Add(p.ejection)
+	p.mu.Lock()
+	account.failures++
+	account.lastError = err.Error()
+	if eject {
+		account.ejectedUntil = until
+		account.ejections++
+	}
+	p.mu.Unlock()
+	if eject {
+		upstreamAccountEjections.WithLabelValues(account.name).Inc()
+		yandexLog.WarnCtx(ctx, "account ejected", "account", account.name, "until", until, "upstream_status", statusErr.Code)
+	} else {
+		yandexLog.DebugCtx(ctx, "account failed", "account", account.name, slog.ErrorKey, err)
+	}
+	return len(p.accounts) > 1
+}
+
+// order returns the available accounts by the strategy, the ejected ones are tried only if no account is available
+func (p *UpstreamPool) order() []*poolAccount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	var available, ejected []*poolAccount
+	for _, account := range p.accounts {
+		if account.ejectedUntil.After(now) {
+			ejected = append(ejected, account)
+		} else {
+			available = append(available, account)
+		}
+	}
+	if len(available) == 0 {
+		sort.SliceStable(ejected, func(i, j int) bool { return ejected[i].ejectedUntil.Before(ejected[j].ejectedUntil) })
+		return ejected
+	}
+	switch p.strategy {
+	case poolWeighted:
+		//smooth weighted round-robin selects the first, others follow by weight
+		total := 0
+		var selected *poolAccount
+		for _, account := range available {
+			account.currentWeight += account.weight
+			total += account.weight
+			if selected == nil || account.currentWeight > selected.currentWeight {
+				selected = account
+			}
+		}
+		selected.currentWeight -= total
+		sort.SliceStable(available, func(i, j int) bool {
+			return available[i] == selected || available[j] != selected && available[i].weight > available[j].weight
+		})
+	case poolCheapestFirst:
+		sort.SliceStable(available, func(i, j int) bool { return available[i].cost < available[j].cost })
+	default:
+		start := p.next % len(available)
+		p.next++
+		available = append(append(make([]*poolAccount, 0, len(available)), available[start:]...), available[:start]...)
+	}
+	return available
+}
+
+// States returns the accounts states in the configuration order
+func (p *UpstreamPool) States() []AccountState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	now := time.Now()
+	states := make([]AccountState, len(p.accounts))
+	for i, account := range p.accounts {
+		state := AccountState{
+			Name:         account.name,
+			FolderID:     account.client.Config().FolderID,
+			Available:    !account.ejectedUntil.After(now),
+			EjectedUntil: account.ejectedUntil,
+			Ejections:    account.ejections,
+			Failures:     account.failures,
+			LastError:    account.lastError,
+			IamTokenOK:   account.client.IamTokenState().Valid,
+		}
+		states[i] = state
+	}
+	return states
+}
@@ -2,54 +2,102 @@ package main
 
 import (
 	"errors"
-	"fmt"
 	"io/fs"
 	"io/ioutil"
 	"os"
 	"path"
+	"sync"
 	"time"
 
 	"gopkg.in/yaml.v2"
 )
 
 type Config struct {
-	FolderID       string
-	OAuthToken     string
-	IamToken       string
-	IamTokenExpire time.Time
+	FolderID   string
+	OAuthToken string
+	//authorized key file of a service account, it is used instead of the OAuth token
+	ServiceAccountKeyFile string
+	IamToken              string
+	IamTokenExpire        time.Time
+	Translator            string
+	RateLimits            RateLimits `yaml:",omitempty"`
+	//clients must send one of the keys if any is configured
+	APIKeys []APIKey `yaml:",omitempty"`
+	//weight and cost of the default account in the upstream pool
+	Weight int     `yaml:",omitempty"`
+	Cost   float64 `yaml:",omitempty"`
+	//additional upstream accounts, calls are spread across them and the default one
+	Accounts []Account `yaml:",omitempty"`
+	//server-side glossaries attached to translate requests of their language pairs
+	Glossaries []Glossary `yaml:",omitempty"`
+	//flag values, the flags and environment variables override them
+	Settings *Settings `yaml:",omitempty"`
 }
 
 func ReadConfig(file string) (*Config, error) {
-	logDebugf("read config file %s", file)
+	configLog.Debug("read config file", "file", file)
 	config := new(Config)
 	if payload, err := ioutil.ReadFile(file); err != nil {
 		if errors.Is(err, fs.ErrNotExist) {
-			logDebugf("config file not found")
+			configLog.Debug("config file not found", "file", file)
 		} else {
 			return nil, err
 		}
-	} else if err := yaml.Unmarshal(payload, config); err != nil {
+	} else if err := yaml.UnmarshalStrict(payload, config); err != nil {
 		return nil, err
 	}
 	return config, nil
 }
 
+// WriteConfig replaces the file by a temporary one, so a reader never sees a partially written config
 func WriteConfig(config *Config, file string) error {
-	logDebugf("write config file %s", file)
-	if payload, err := yaml.Marshal(config); err != nil {
+	configLog.Debug("write config file", "file", file)
+	payload, err := yaml.Marshal(config)
+	if err != nil {
 		return err
 	} else if err := os.MkdirAll(path.Dir(file), os.ModePerm); err != nil {
 		return err
+	}
+	tmp, err := os.CreateTemp(path.Dir(file), path.Base(file)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }()
+	if _, err := tmp.Write(payload); err != nil {
+		_ = tmp.Close()
+		return err
+	} else if err := tmp.Close(); err != nil {
+		return err
+	} else if err := os.Chmod(tmp.Name(), 0600); err != nil {
+		return err
+	} else if err := os.Rename(tmp.Name(), file); err != nil {
+		return err
+	} else if info, err := os.Stat(file); err != nil {
+		return err
 	} else {
-		return ioutil.WriteFile(file, payload, os.ModePerm)
+		writtenConfigs.Store(file, info)
+		return nil
+	}
+}
+
+// writtenConfigs keeps the file info of the config files written by the proxy to tell its own writes from external changes
+var writtenConfigs sync.Map
+
+// isWrittenConfig reports whether the file is not changed since the proxy wrote it
+func isWrittenConfig(file string, info fs.FileInfo) bool {
+	written, ok := writtenConfigs.Load(file)
+	if !ok {
+		return false
 	}
+	writtenInfo := written.(fs.FileInfo)
+	return os.SameFile(writtenInfo, info) && writtenInfo.ModTime().Equal(info.ModTime()) && writtenInfo.Size() == info.Size()
 }
 
 func (config *Config) Store(file string) {
 	if len(file) == 0 {
 		return
 	} else if err := WriteConfig(config, file); err != nil {
-		logError(fmt.Errorf("wirte config file: %w", err))
+		configLog.Error("write config file", err, "file", file)
 	}
 }
 
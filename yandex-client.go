@@ -2,6 +2,7 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"errors"
 	"fmt"
@@ -10,64 +11,134 @@ import (
 	"net/http"
 	"net/url"
 	"path"
+	"strconv"
+	"sync/atomic"
 	"time"
+
+	"golang.org/x/exp/slog"
 )
 
 type HTTPStatusError struct {
-	Code   int
-	status string
-	body   string
+	Code       int
+	RetryAfter string
+	status     string
+	body       string
 }
 
 func (e *HTTPStatusError) Error() string {
 	return fmt.Sprintf("invalid status %d : %s, response\n%s", e.Code, e.status, e.body)
 }
 
+// Message returns the upstream error message or the status if there is no message
+func (e *HTTPStatusError) Message() string {
+	if message := upstreamMessage(e.body); len(message) > 0 {
+		return message
+	}
+	return e.status
+}
+
 var _ error = (*HTTPStatusError)(nil)
 
-func NewYandexClient(configFile string, writeableConfig bool, config *Config, client *http.Client, iamTokenURL, cloudsURL, foldersURL, translateURL string) (*YandexClient, error) {
+func NewYandexClient(configFile string, writeableConfig bool, config *Config, client *http.Client, iamTokenURL, cloudsURL, foldersURL, translateURL, detectURL, languagesURL string,
+	iamTokenRefreshBefore time.Duration, limits TranslateLimits, retry RetryPolicy) (*YandexClient, error) {
 	fURL, err := url.Parse(foldersURL)
 	if err != nil {
 		return nil, fmt.Errorf("invalid folders URL %s; %w", foldersURL, err)
 	}
-	return &YandexClient{configFile: configFile, writeableConfig: writeableConfig, Config: config, client: client, iamTokenURL: iamTokenURL, cloudsURL: cloudsURL, foldersURL: *fURL, translateURL: translateURL}, nil
+	c := &YandexClient{client: client, iamTokenURL: iamTokenURL, cloudsURL: cloudsURL,
+		foldersURL: *fURL, translateURL: translateURL, detectURL: detectURL, languagesURL: languagesURL, limits: limits, retry: retry}
+	var store func(config Config)
+	if writeableConfig {
+		store = func(config Config) { config.Store(configFile) }
+	}
+	c.iamTokens = NewIamTokenManager(config, c.RequestIamToken, store, iamTokenRefreshBefore)
+	return c, nil
 }
 
+const (
+	listPageSize = 100
+)
+
+var (
+	_ Translator       = (*YandexClient)(nil)
+	_ CharacterCounter = (*YandexClient)(nil)
+)
+
 type YandexClient struct {
-	configFile      string
-	writeableConfig bool
-	Config          *Config
-	client          *http.Client
-	iamTokenURL     string
-	cloudsURL       string
-	foldersURL      url.URL
-	translateURL    string
+	client       *http.Client
+	iamTokenURL  string
+	cloudsURL    string
+	foldersURL   url.URL
+	translateURL string
+	detectURL    string
+	languagesURL string
+	iamTokens    *IamTokenManager
+	limits       TranslateLimits
+	retry        RetryPolicy
+	//characters sent by successful translate requests
+	translatedCharacters atomic.Int64
 }
 
 func (c *YandexClient) GetClouds() (*CloudsResponse, error) {
-	respPayload := new(CloudsResponse)
-	if iamToken, err := c.getStoreIamToken(); err != nil {
-		return nil, err
-	} else if err := doGetRequest("clouds", c.client, c.cloudsURL, iamToken, respPayload); err != nil {
+	clouds, err := c.Clouds("").Collect()
+	if err != nil {
 		return nil, err
-	} else {
-		return respPayload, nil
 	}
+	return &CloudsResponse{Clouds: clouds}, nil
+}
+
+// Clouds iterates over the clouds, the filter is a server-side expression like name="my-cloud"
+func (c *YandexClient) Clouds(filter string) *PageIterator[Cloud] {
+	return NewPageIterator(func(pageToken string) ([]Cloud, string, error) {
+		u, err := url.Parse(c.cloudsURL)
+		if err != nil {
+			return nil, "", fmt.Errorf("invalid clouds URL %s; %w", c.cloudsURL, err)
+		}
+		u.RawQuery = pageQuery(u.Query(), pageToken, filter).Encode()
+		respPayload := new(CloudsResponse)
+		if iamToken, err := c.iamTokens.Token(context.Background()); err != nil {
+			return nil, "", err
+		} else if err := doGetRequest(context.Background(), "clouds", c.client, c.retry, u.String(), iamToken, respPayload); err != nil {
+			return nil, "", err
+		}
+		return respPayload.Clouds, respPayload.NextPageToken, nil
+	})
 }
 
 func (c *YandexClient) GetCloudFolders(cloudID string) (*FoldersResponse, error) {
-	f := c.foldersURL
-	q := f.Query()
-	q.Set("cloudId", cloudID)
-	f.RawQuery = q.Encode()
-	respPayload := new(FoldersResponse)
-	if iamToken, err := c.getStoreIamToken(); err != nil {
-		return nil, err
-	} else if err := doGetRequest("cloud folders", c.client, f.String(), iamToken, respPayload); err != nil {
+	folders, err := c.CloudFolders(cloudID, "").Collect()
+	if err != nil {
 		return nil, err
-	} else {
-		return respPayload, nil
 	}
+	return &FoldersResponse{Folders: folders}, nil
+}
+
+// CloudFolders iterates over the cloud folders, the filter is a server-side expression like name="my-folder"
+func (c *YandexClient) CloudFolders(cloudID, filter string) *PageIterator[Folder] {
+	return NewPageIterator(func(pageToken string) ([]Folder, string, error) {
+		f := c.foldersURL
+		q := f.Query()
+		q.Set("cloudId", cloudID)
+		f.RawQuery = pageQuery(q, pageToken, filter).Encode()
+		respPayload := new(FoldersResponse)
+		if iamToken, err := c.iamTokens.Token(context.Background()); err != nil {
+			return nil, "", err
+		} else if err := doGetRequest(context.Background(), "cloud folders", c.client, c.retry, f.String(), iamToken, respPayload); err != nil {
+			return nil, "", err
+		}
+		return respPayload.Folders, respPayload.NextPageToken, nil
+	})
+}
+
+func pageQuery(q url.Values, pageToken, filter string) url.Values {
+	q.Set("pageSize", strconv.Itoa(listPageSize))
+	if len(pageToken) > 0 {
+		q.Set("pageToken", pageToken)
+	}
+	if len(filter) > 0 {
+		q.Set("filter", filter)
+	}
+	return q
 }
 
 func (c *YandexClient) CreateCloudFolder(cloudID, name string) (*CreateFolderResponse, error) {
@@ -77,9 +148,9 @@ func (c *YandexClient) CreateCloudFolder(cloudID, name string) (*CreateFolderRes
 		Name:    name,
 	}
 	respPayload := new(CreateFolderResponse)
-	if iamToken, err := c.getStoreIamToken(); err != nil {
+	if iamToken, err := c.iamTokens.Token(context.Background()); err != nil {
 		return nil, err
-	} else if err := doPostRequest("create folder", c.client, f.String(), iamToken, reqPayload, respPayload, false); err != nil {
+	} else if err := doPostRequest(context.Background(), "create folder", c.client, RetryPolicy{Timeout: c.retry.Timeout}, f.String(), iamToken, reqPayload, respPayload, false); err != nil {
 		return nil, err
 	} else {
 		return respPayload, nil
@@ -87,125 +158,236 @@ func (c *YandexClient) CreateCloudFolder(cloudID, name string) (*CreateFolderRes
 }
 
 func (c *YandexClient) GetCloudFolder(folderID string) (*GetFolderResponse, error) {
+	return c.getCloudFolder(context.Background(), folderID, c.retry)
+}
+
+func (c *YandexClient) getCloudFolder(ctx context.Context, folderID string, retry RetryPolicy) (*GetFolderResponse, error) {
 	f := c.foldersURL
 	f.Path = path.Join(f.Path, folderID)
 
 	respPayload := new(GetFolderResponse)
-	if iamToken, err := c.getStoreIamToken(); err != nil {
+	if iamToken, err := c.iamTokens.Token(ctx); err != nil {
 		return nil, err
-	} else if err := doGetRequest("create folder", c.client, f.String(), iamToken, respPayload); err != nil {
+	} else if err := doGetRequest(ctx, "get folder", c.client, retry, f.String(), iamToken, respPayload); err != nil {
 		return nil, err
 	} else {
 		return respPayload, nil
 	}
 }
 
-func (c *YandexClient) RequestIamToken() (*IamTokenResponse, error) {
+// Config returns a snapshot of the current config
+func (c *YandexClient) Config() Config {
+	return c.iamTokens.Config()
+}
+
+// Reload swaps the config, changed credentials are checked by a new IAM token request to keep the current config if they are rejected
+func (c *YandexClient) Reload(config Config) error {
+	current := c.Config()
+	var token *IamTokenResponse
+	if config.OAuthToken != current.OAuthToken || config.ServiceAccountKeyFile != current.ServiceAccountKeyFile {
+		ctx, cancel := context.WithTimeout(context.Background(), iamTokenRequestTimeout)
+		defer cancel()
+		var err error
+		if token, err = c.requestIamToken(ctx, config); err != nil {
+			return fmt.Errorf("check new credentials: %w", err)
+		}
+	}
+	c.iamTokens.Reload(config, token)
+	return nil
+}
+
+func (c *YandexClient) RequestIamToken(ctx context.Context) (*IamTokenResponse, error) {
+	return c.requestIamToken(ctx, c.Config())
+}
+
+func (c *YandexClient) requestIamToken(ctx context.Context, config Config) (*IamTokenResponse, error) {
 	method := "requestIamToken"
 	respPayload := new(IamTokenResponse)
-	iamTokenRequest := IamTokenRequest{YandexPassportOauthToken: c.Config.OAuthToken}
-	if reqBody, err := json.Marshal(&iamTokenRequest); err != nil {
-		return nil, fmt.Errorf("%s request marshal %+v: %w", method, iamTokenRequest, err)
-	} else if req, err := http.NewRequest(http.MethodPost, c.iamTokenURL, bytes.NewReader(reqBody)); err != nil {
+	iamTokenRequest, err := c.newIamTokenRequest(config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", method, err)
+	}
+	if reqBody, err := json.Marshal(iamTokenRequest); err != nil {
+		return nil, fmt.Errorf("%s request marshal: %w", method, err)
+	} else if req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.iamTokenURL, bytes.NewReader(reqBody)); err != nil {
 		return nil, fmt.Errorf("%s request %w", method, err)
-	} else if err := doRequest(method, c.client, req, respPayload, false); err != nil {
+	} else if err := doRequest(method, c.client, c.retry, req, respPayload, false); err != nil {
 		return nil, err
 	}
-	fmt.Printf("requested Yandex API IAM token %s, expired at %s\n", respPayload.IamToken, respPayload.ExpiresAt)
+	yandexLog.Info("requested IAM token", "token", maskSecret(respPayload.IamToken), "expires_at", respPayload.ExpiresAt)
 	return respPayload, nil
 }
 
-func (c *YandexClient) Translate(request *TranslateRequest) (*TranslateResponse, error) {
+// newIamTokenRequest makes the request by the service account key if it is configured, otherwise by the OAuth token
+func (c *YandexClient) newIamTokenRequest(config Config) (*IamTokenRequest, error) {
+	keyFile := config.ServiceAccountKeyFile
+	if len(keyFile) == 0 {
+		return &IamTokenRequest{YandexPassportOauthToken: config.OAuthToken}, nil
+	}
+	key, err := ReadServiceAccountKey(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("read service account key file %s: %w", keyFile, err)
+	}
+	jwt, err := key.SignedJWT(c.iamTokenURL, time.Now())
+	if err != nil {
+		return nil, err
+	}
+	return &IamTokenRequest{JWT: jwt}, nil
+}
+
+func (c *YandexClient) Translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
 	if len(request.FolderID) == 0 {
-		request.FolderID = c.Config.FolderID
+		request.FolderID = c.Config().FolderID
 	}
+	return translateChunked(ctx, request, c.limits, c.translate)
+}
+
+func (c *YandexClient) translate(ctx context.Context, request *TranslateRequest) (*TranslateResponse, error) {
 	resp := new(TranslateResponse)
-	if iamToken, err := c.getStoreIamToken(); err != nil {
+	if err := doTranslateAPIRequest(ctx, c, "translate", c.translateURL, request, resp); err != nil {
 		return nil, err
-	} else if err := doPostRequest("translate", c.client, c.translateURL, iamToken, request, resp, true); err != nil {
+	}
+	characters := countCharacters(request.Texts)
+	c.translatedCharacters.Add(int64(characters))
+	translatedCharactersTotal.WithLabelValues(languagePair(request.SourceLanguageCode, request.TargetLanguageCode)).Add(float64(characters))
+	return resp, nil
+}
+
+func (c *YandexClient) TranslatedCharacters() int64 {
+	return c.translatedCharacters.Load()
+}
+
+func (c *YandexClient) Detect(ctx context.Context, request *DetectRequest) (*DetectResponse, error) {
+	if len(request.FolderID) == 0 {
+		request.FolderID = c.Config().FolderID
+	}
+	resp := new(DetectResponse)
+	if err := doTranslateAPIRequest(ctx, c, "detect", c.detectURL, request, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *YandexClient) ListLanguages(ctx context.Context, request *ListLanguagesRequest) (*ListLanguagesResponse, error) {
+	if len(request.FolderID) == 0 {
+		request.FolderID = c.Config().FolderID
+	}
+	resp := new(ListLanguagesResponse)
+	if err := doTranslateAPIRequest(ctx, c, "languages", c.languagesURL, request, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func doTranslateAPIRequest[Req, Resp any](ctx context.Context, c *YandexClient, methodName string, url string, request *Req, resp *Resp) error {
+	if iamToken, err := c.iamTokens.Token(ctx); err != nil {
+		return err
+	} else if err := doPostRequest(ctx, methodName, c.client, c.retry, url, iamToken, request, resp, true); err != nil {
 		var statusErr *HTTPStatusError
 		if errors.As(err, &statusErr) && statusErr.Code == 401 {
-			logDebugf("unauthorized translate request, trying to refresh token, message: %s", statusErr.Error())
-			if iamToken, err = c.refreshIamToken(c.writeableConfig); err != nil {
-				return nil, err
-			} else if err := doPostRequest("translate", c.client, c.translateURL, iamToken, request, resp, true); err != nil {
-				return nil, err
+			yandexLog.DebugCtx(ctx, "unauthorized request, trying to refresh token", "method", methodName, "upstream_status", statusErr.Code, "message", statusErr.Message())
+			if iamToken, err = c.iamTokens.Refresh(ctx, iamToken); err != nil {
+				return err
+			} else if err := doPostRequest(ctx, methodName, c.client, c.retry, url, iamToken, request, resp, true); err != nil {
+				return err
 			}
 		} else {
-			return nil, err
+			return err
 		}
 	}
-	return resp, nil
+	return nil
 }
 
 func (c *YandexClient) GetIamToken() (string, error) {
-	return c.getIamToken(false)
+	return c.iamTokens.Token(context.Background())
 }
 
-func (c *YandexClient) getStoreIamToken() (string, error) {
-	return c.getIamToken(c.writeableConfig)
+func (c *YandexClient) IamTokenState() IamTokenState {
+	return c.iamTokens.State()
 }
 
-func (c *YandexClient) getIamToken(store bool) (string, error) {
-	if c.Config.IsIamTokenExpired() {
-		return c.refreshIamToken(store)
-	}
-	return c.Config.IamToken, nil
+// RunIamTokenRefresh refreshes the IAM token in background until the ctx is done
+func (c *YandexClient) RunIamTokenRefresh(ctx context.Context) {
+	c.iamTokens.Run(ctx)
 }
 
-func (c *YandexClient) refreshIamToken(store bool) (string, error) {
-	tokenResp, err := c.RequestIamToken()
-	if err != nil {
-		return "", fmt.Errorf("request IAM token: %w", err)
-	}
-	iamToken := tokenResp.IamToken
-	//todo: need lock
-	c.Config.UpdateIamToken(iamToken, tokenResp.ExpiresAt)
-	if store {
-		c.Config.Store(c.configFile)
-	}
-	return iamToken, nil
+// Flush persists the IAM token state, a refresh in progress is awaited until the ctx is done
+func (c *YandexClient) Flush(ctx context.Context) {
+	c.iamTokens.Flush(ctx)
 }
 
-func doGetRequest[T any](methodName string, client *http.Client, url string, iamToken string, resp *T) error {
-	return doAuthRequest(methodName, client, http.MethodGet, url, iamToken, nil, resp, false)
+func doGetRequest[T any](ctx context.Context, methodName string, client *http.Client, retry RetryPolicy, url string, iamToken string, resp *T) error {
+	return doAuthRequest(ctx, methodName, client, retry, http.MethodGet, url, iamToken, nil, resp, false)
 }
 
-func doPostRequest[Req, Resp any](methodName string, client *http.Client, url string, iamToken string, req *Req, resp *Resp, logging bool) error {
+func doPostRequest[Req, Resp any](ctx context.Context, methodName string, client *http.Client, retry RetryPolicy, url string, iamToken string, req *Req, resp *Resp, logging bool) error {
 	requestBody, err := json.Marshal(req)
-	if logging {
-		logPayload("->", requestBody)
-	}
 	if err != nil {
-		return fmt.Errorf("request marshal %+v: %w", req, err)
+		//the request isn't printed because it contains users' texts
+		return fmt.Errorf("%s request marshal: %w", methodName, err)
 	}
-	return doAuthRequest(methodName, client, http.MethodPost, url, iamToken, bytes.NewReader(requestBody), resp, logging)
+	if logging {
+		ctx = withPayloadSampling(ctx)
+		logPayload(ctx, "->", methodName, requestBody)
+	}
+	return doAuthRequest(ctx, methodName, client, retry, http.MethodPost, url, iamToken, bytes.NewReader(requestBody), resp, logging)
 }
 
-func doAuthRequest[T any](callName string, client *http.Client, httpMethod string, url string, iamToken string, reqBody io.Reader, respReceiver *T, logging bool) error {
-	req, err := http.NewRequest(httpMethod, url, reqBody)
+func doAuthRequest[T any](ctx context.Context, callName string, client *http.Client, retry RetryPolicy, httpMethod string, url string, iamToken string, reqBody io.Reader, respReceiver *T, logging bool) error {
+	req, err := http.NewRequestWithContext(ctx, httpMethod, url, reqBody)
 	if err != nil {
 		return fmt.Errorf("%s %s request: %w", callName, httpMethod, err)
 	}
 	req.Header.Set("Authorization", "Bearer "+iamToken)
-	return doRequest(callName, client, req, respReceiver, logging)
+	return doRequest(callName, client, retry, req, respReceiver, logging)
+}
+
+// doRequest repeats the request by the retry policy until the request context is done
+func doRequest[T any](methodName string, client *http.Client, retry RetryPolicy, req *http.Request, respPayload *T, logging bool) error {
+	if retry.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), retry.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+	return retry.do(req.Context(), methodName, func(attempt int) error {
+		attemptReq := req
+		if attempt > 1 && req.GetBody != nil {
+			//the body of the previous attempt has been already read
+			body, err := req.GetBody()
+			if err != nil {
+				return fmt.Errorf(methodName+" request body: %w", err)
+			}
+			attemptReq = req.Clone(req.Context())
+			attemptReq.Body = body
+		}
+		return doAttempt(methodName, client, attemptReq, respPayload, logging)
+	})
 }
 
-func doRequest[T any](methodName string, client *http.Client, req *http.Request, respPayload *T, logging bool) error {
-	if resp, err := client.Do(req); err != nil {
+func doAttempt[T any](methodName string, client *http.Client, req *http.Request, respPayload *T, logging bool) error {
+	start := time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		upstreamDuration.WithLabelValues(methodName, "error").Observe(time.Since(start).Seconds())
+		upstreamHealth.record(0, err)
+		yandexLog.DebugCtx(req.Context(), "upstream call failed", "method", methodName, "duration", time.Since(start), slog.ErrorKey, err)
 		return fmt.Errorf(methodName+" response: %w", err)
-	} else if resp.StatusCode != 200 {
+	}
+	upstreamDuration.WithLabelValues(methodName, strconv.Itoa(resp.StatusCode)).Observe(time.Since(start).Seconds())
+	upstreamHealth.record(resp.StatusCode, nil)
+	yandexLog.DebugCtx(req.Context(), "upstream call", "method", methodName, "upstream_status", resp.StatusCode, "duration", time.Since(start))
+	if resp.StatusCode != 200 {
 		payload, _ := readBody(resp)
-		return &HTTPStatusError{Code: resp.StatusCode, status: resp.Status, body: string(payload)}
+		return &HTTPStatusError{Code: resp.StatusCode, RetryAfter: resp.Header.Get("Retry-After"), status: resp.Status, body: string(payload)}
 	} else if bodyRawPayload, err := readBody(resp); err != nil {
-		return fmt.Errorf(methodName+" response payload read %s: %w", string(bodyRawPayload), err)
+		return fmt.Errorf(methodName+" response payload read: %w", err)
 	} else if bodyRawPayload == nil {
 		return nil
 	} else if err = json.Unmarshal(bodyRawPayload, respPayload); err != nil {
-		return fmt.Errorf(methodName+" response payload unmarshal %s: %w", string(bodyRawPayload), err)
+		return fmt.Errorf(methodName+" response payload unmarshal, length %d: %w", len(bodyRawPayload), err)
 	} else {
 		if logging {
-			logPayload("<-", bodyRawPayload)
+			logPayload(req.Context(), "<-", methodName, bodyRawPayload)
 		}
 		return nil
 	}
@@ -227,7 +409,8 @@ func readBody(resp *http.Response) ([]byte, error) {
 }
 
 type IamTokenRequest struct {
-	YandexPassportOauthToken string `json:"yandexPassportOauthToken"`
+	YandexPassportOauthToken string `json:"yandexPassportOauthToken,omitempty"`
+	JWT                      string `json:"jwt,omitempty"`
 }
 
 type IamTokenResponse struct {
@@ -294,11 +477,18 @@ type Cloud struct {
 	Labels         string `json:"labels"`
 }
 
+const (
+	formatHTML = "HTML"
+)
+
 type TranslateRequest struct {
 	FolderID           string   `json:"folderId"`
 	Texts              []string `json:"texts"`
 	SourceLanguageCode string   `json:"sourceLanguageCode"`
 	TargetLanguageCode string   `json:"targetLanguageCode"`
+	Format             string   `json:"format,omitempty"`
+	//pairs passed by the client and attached from the server-side glossaries
+	GlossaryConfig *GlossaryConfig `json:"glossaryConfig,omitempty"`
 }
 
 type TranslateResponse struct {
@@ -309,3 +499,26 @@ type Translation struct {
 	Text                 string `json:"text"`
 	DetectedLanguageCode string `json:"detectedLanguageCode"`
 }
+
+type DetectRequest struct {
+	FolderID          string   `json:"folderId"`
+	Text              string   `json:"text"`
+	LanguageCodeHints []string `json:"languageCodeHints,omitempty"`
+}
+
+type DetectResponse struct {
+	LanguageCode string `json:"languageCode"`
+}
+
+type ListLanguagesRequest struct {
+	FolderID string `json:"folderId"`
+}
+
+type ListLanguagesResponse struct {
+	Languages []Language `json:"languages"`
+}
+
+type Language struct {
+	Code string `json:"code"`
+	Name string `json:"name"`
+}
@@ -0,0 +1,236 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/m4gshm/gollections/slice"
+	"golang.org/x/exp/slog"
+)
+
+const (
+	v1_5OptionDetectedLanguage = 1
+)
+
+var v1_5CallbackPattern = regexp.MustCompile(`^[A-Za-z_$][0-9A-Za-z_$.]*$`)
+
+func (h *Handler) v1_5Translate(response http.ResponseWriter, request *http.Request) {
+	callback, err := parseV1_5Form(request)
+	if err != nil {
+		writeV1_5Error(response, request, callback, err)
+		return
+	}
+	form := request.Form
+	texts := form["text"]
+	if len(texts) == 0 {
+		writeV1_5Error(response, request, callback, newValidationError("invalid parameter: text"))
+	} else if srcLang, destLang, err := parseV1_5Languages(form.Get("lang")); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else if format, err := parseV1_5Format(form.Get("format")); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else if options, err := parseV1_5Options(form.Get("options")); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else if result, err := h.translate(request.Context(), &TranslateRequest{
+		Texts:              texts,
+		SourceLanguageCode: srcLang,
+		TargetLanguageCode: destLang,
+		Format:             format,
+	}); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else {
+		writeV1_5Response(response, callback, toV1_5TranslateResponse(result, srcLang, destLang, options&v1_5OptionDetectedLanguage != 0))
+	}
+}
+
+func (h *Handler) v1_5Detect(response http.ResponseWriter, request *http.Request) {
+	callback, err := parseV1_5Form(request)
+	if err != nil {
+		writeV1_5Error(response, request, callback, err)
+		return
+	}
+	form := request.Form
+	var hints []string
+	if hint := form.Get("hint"); len(hint) > 0 {
+		hints = strings.Split(hint, ",")
+	}
+	if result, err := h.detect(request.Context(), &DetectRequest{Text: form.Get("text"), LanguageCodeHints: hints}); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else {
+		writeV1_5Response(response, callback, &V1_5DetectResponse{Code: http.StatusOK, Lang: result.LanguageCode})
+	}
+}
+
+func (h *Handler) v1_5GetLangs(response http.ResponseWriter, request *http.Request) {
+	callback, err := parseV1_5Form(request)
+	if err != nil {
+		writeV1_5Error(response, request, callback, err)
+		return
+	}
+	if result, err := h.listLanguages(request.Context(), &ListLanguagesRequest{}); err != nil {
+		writeV1_5Error(response, request, callback, err)
+	} else {
+		writeV1_5Response(response, callback, toV1_5LangsResponse(result, len(request.Form.Get("ui")) > 0))
+	}
+}
+
+// parseV1_5Form parses query and form-encoded body parameters and returns the JSONP callback name
+func parseV1_5Form(request *http.Request) (string, error) {
+	err := request.ParseForm()
+	callback := request.Form.Get("callback")
+	if len(callback) > 0 && !v1_5CallbackPattern.MatchString(callback) {
+		return "", newValidationError("invalid parameter: callback")
+	} else if err != nil {
+		return callback, newValidationError("request parse: %w", err)
+	}
+	return callback, nil
+}
+
+func parseV1_5Languages(lang string) (string, string, error) {
+	if strings.Contains(lang, "-") {
+		return splitSrcDestLanguages(lang)
+	} else if len(lang) == 0 {
+		return "", "", newValidationError("invalid parameter: lang")
+	}
+	//destination language only, the source must be detected
+	return "", lang, nil
+}
+
+func parseV1_5Format(format string) (string, error) {
+	switch format {
+	case "", "plain":
+		return "", nil
+	case "html":
+		return formatHTML, nil
+	default:
+		return "", newValidationError("invalid parameter: format %s", format)
+	}
+}
+
+func parseV1_5Options(options string) (int, error) {
+	if len(options) == 0 {
+		return 0, nil
+	} else if o, err := strconv.Atoi(options); err != nil {
+		return 0, newValidationError("invalid parameter: options %s", options)
+	} else {
+		return o, nil
+	}
+}
+
+func splitSrcDestLanguages(language string) (string, string, error) {
+	if len(language) == 0 {
+		return "", "", newValidationError("empty source-destination languages format (expected SRC-DST)")
+	}
+	if !strings.Contains(language, "-") {
+		return "", "", newValidationError("bad source-destination languages format %s (expected SRC-DST)", language)
+	}
+
+	ls := strings.Split(language, "-")
+
+	if len(ls) != 2 {
+		return "", "", newValidationError("unexpected source-destination languages format %s (expected SRC-DST)", language)
+	}
+	srcLang, destLang := ls[0], ls[1]
+	if len(srcLang) == 0 {
+		return "", "", newValidationError("bad source language: %s", language)
+	}
+	if len(destLang) == 0 {
+		return "", "", newValidationError("bad destination language: %s", language)
+	}
+	return srcLang, destLang, nil
+}
+
+func writeV1_5Response(response http.ResponseWriter, callback string, payload any) {
+	writeV1_5(response, callback, http.StatusOK, payload)
+}
+
+func writeV1_5Error(response http.ResponseWriter, request *http.Request, callback string, err error) {
+	apiErr := writeAPIError(response, request, err)
+	writeV1_5(response, callback, apiErr.Status, &V1_5ErrorResponse{Code: apiErr.Status, Message: apiErr.Message})
+}
+
+func writeV1_5(response http.ResponseWriter, callback string, status int, payload any) {
+	if len(callback) == 0 {
+		writeJSON(response, status, payload)
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		serverLog.Error("response marshal", err)
+		http.Error(response, "internal error", http.StatusInternalServerError)
+		return
+	}
+	cors(response)
+	response.Header().Set("Content-Type", "application/javascript; charset=utf-8")
+	body = []byte(callback + "(" + string(body) + ");")
+	response.WriteHeader(status)
+	if _, err := response.Write(body); err != nil {
+		serverLog.Debug("response write", slog.ErrorKey, err)
+	}
+}
+
+func toV1_5TranslateResponse(result *TranslateResponse, srcLang, destLang string, detected bool) *V1_5TranslateResponse {
+	detectedLang := srcLang
+	if len(detectedLang) == 0 && len(result.Translations) > 0 {
+		detectedLang = result.Translations[0].DetectedLanguageCode
+	}
+	resp := &V1_5TranslateResponse{
+		Code: http.StatusOK,
+		Lang: detectedLang + "-" + destLang,
+		Text: slice.Convert(result.Translations, func(t Translation) string { return t.Text }),
+	}
+	if detected {
+		resp.Detected = &V1_5Detected{Lang: detectedLang}
+	}
+	return resp
+}
+
+func toV1_5LangsResponse(result *ListLanguagesResponse, withNames bool) *V1_5LangsResponse {
+	codes := slice.Convert(result.Languages, func(l Language) string { return l.Code })
+	sort.Strings(codes)
+	dirs := make([]string, 0)
+	for _, src := range codes {
+		for _, dest := range codes {
+			if src != dest {
+				dirs = append(dirs, src+"-"+dest)
+			}
+		}
+	}
+	resp := &V1_5LangsResponse{Dirs: dirs}
+	if withNames {
+		resp.Langs = make(map[string]string, len(result.Languages))
+		for _, l := range result.Languages {
+			resp.Langs[l.Code] = l.Name
+		}
+	}
+	return resp
+}
+
+type V1_5TranslateResponse struct {
+	Code     int           `json:"code"`
+	Lang     string        `json:"lang"`
+	Detected *V1_5Detected `json:"detected,omitempty"`
+	Text     []string      `json:"text"`
+}
+
+type V1_5Detected struct {
+	Lang string `json:"lang"`
+}
+
+type V1_5DetectResponse struct {
+	Code int    `json:"code"`
+	Lang string `json:"lang"`
+}
+
+type V1_5LangsResponse struct {
+	Dirs  []string          `json:"dirs"`
+	Langs map[string]string `json:"langs,omitempty"`
+}
+
+type V1_5ErrorResponse struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}